@@ -13,6 +13,9 @@ import (
 	"os/signal"
 	"strconv"
 	"strings"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/gorilla/websocket"
 	"github.com/moisespsena-go/httpdx/internal"
@@ -25,9 +28,57 @@ type Listener struct {
 	l  net.Listener
 }
 
+// connections tracks the active local connections.
+type connections struct {
+	mu    sync.Mutex
+	conns map[net.Conn]bool
+	wg    sync.WaitGroup
+}
+
+func (c *connections) add(con net.Conn) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.conns[con] = true
+	c.wg.Add(1)
+}
+
+func (c *connections) done(con net.Conn) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conns[con] {
+		delete(c.conns, con)
+		c.wg.Done()
+	}
+}
+
+// drain waits for the active connections until timeout, then closes the
+// remaining connections. Returns the number of closed connections.
+func (c *connections) drain(timeout time.Duration) (closed int) {
+	done := make(chan struct{})
+	go func() {
+		c.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return
+	case <-time.After(timeout):
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for con := range c.conns {
+		con.Close()
+		closed++
+	}
+	return
+}
+
 func Run(cfg *Config) (err error) {
 	interrupt := make(chan os.Signal, 1)
-	signal.Notify(interrupt, os.Interrupt)
+	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
 	serverURL := cfg.ServerURL + internal.ProxyPath
 
 	var u *url.URL
@@ -63,6 +114,7 @@ func Run(cfg *Config) (err error) {
 		listeners []*Listener
 		done      = make(chan int)
 		doneCount int
+		conns     = &connections{conns: map[net.Conn]bool{}}
 	)
 
 	for i, route := range cfg.Routes {
@@ -74,9 +126,10 @@ func Run(cfg *Config) (err error) {
 			route.Auth = cfg.Auth
 		}
 
+		n := len(listeners)
 		if l := runService(func() {
-			done <- i + 1
-		}, i, u, route); l != nil {
+			done <- n + 1
+		}, i, u, route, conns); l != nil {
 			listeners = append(listeners, l)
 		}
 	}
@@ -95,10 +148,18 @@ func Run(cfg *Config) (err error) {
 		}
 	}
 
+	drainTimeout := time.Second * time.Duration(cfg.DrainTimeout)
+	if drainTimeout == 0 {
+		drainTimeout = 30 * time.Second
+	}
+
+	if closed := conns.drain(drainTimeout); closed > 0 {
+		log.Printf("%d active connections were cut", closed)
+	}
 	return
 }
 
-func runService(done func(), i int, u *url.URL, cfg *RouteConfig) (_ *Listener) {
+func runService(done func(), i int, u *url.URL, cfg *RouteConfig, conns *connections) (_ *Listener) {
 	id := "route #" + strconv.Itoa(i) + " {" + cfg.Name + " 🡒 " + cfg.LocalAddr + "}:"
 	log.Println(id, "started")
 
@@ -125,7 +186,11 @@ func runService(done func(), i int, u *url.URL, cfg *RouteConfig) (_ *Listener)
 			{
 				u := *u
 				u.RawQuery = "name=" + cfg.Name
-				go handleConnection(u, id, c, cfg.Auth)
+				conns.add(c)
+				go func() {
+					defer conns.done(c)
+					handleConnection(u, id, c, cfg.Auth)
+				}()
 			}
 		}
 	}()
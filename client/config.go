@@ -17,4 +17,7 @@ type Config struct {
 	ServerURL string         `yaml:"server_url"`
 	Routes    []*RouteConfig `yaml:"routes"`
 	Auth      *AuthConfig    `yaml:"auth"`
+	// DrainTimeout is the time in seconds to wait for the active connections
+	// on shutdown, before close them (default is 30s).
+	DrainTimeout uint8 `yaml:"drain_timeout"`
 }
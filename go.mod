@@ -3,7 +3,9 @@ module github.com/moisespsena-go/httpdx
 go 1.21.1
 
 require (
+	github.com/andybalholm/brotli v1.1.1
 	github.com/gorilla/websocket v1.5.1
+	golang.org/x/crypto v0.17.0
 	gopkg.in/yaml.v3 v3.0.1
 )
 
@@ -1,11 +1,13 @@
 package main
 
 import (
+	"bufio"
 	_ "embed"
 	"errors"
 	"flag"
 	"fmt"
 	"html/template"
+	"io"
 	"os"
 	"path/filepath"
 	"regexp"
@@ -34,6 +36,7 @@ func main() {
 			"  server (default): run as server.\n"+
 			"  client:           run as client.\n"+
 			"  create-config:    create config file.\n"+
+			"  passwd:           print the hash of password.\n"+
 			"  info:             print program information.\n\n")
 		fmt.Fprintf(fs.Output(), "Default Options:\n")
 		fs.PrintDefaults()
@@ -71,6 +74,8 @@ func main() {
 			}
 		case "create-config":
 			err = runCreateConfig(fs, args[1:])
+		case "passwd":
+			err = runPasswd(fs, args[1:])
 		case "info":
 			err = runAbout(fs, args[1:])
 		default:
@@ -107,7 +112,24 @@ func runServer(parent *flag.FlagSet, cfg *server.Config, args []string) (err err
 		}
 		return
 	}
-	return server.Serve(cfg)
+	return server.Serve(cfg, &server.Loader{
+		File: configFile,
+		Load: func() (_ *server.Config, err error) {
+			var (
+				newCfg Config
+				data   []byte
+			)
+			if data, err = os.ReadFile(configFile); err != nil {
+				return
+			}
+			if err = yaml.Unmarshal(data, &newCfg); err != nil {
+				return
+			}
+			// the server address can't be changed at runtime.
+			newCfg.Server.Addr = cfg.Addr
+			return &newCfg.Server, nil
+		},
+	})
 }
 
 var configRe = regexp.MustCompile(`^([^:]+):(.*:\d+)$`)
@@ -207,6 +229,60 @@ func runCreateConfig(parent *flag.FlagSet, args []string) (err error) {
 	})
 }
 
+func runPasswd(parent *flag.FlagSet, args []string) (err error) {
+	var (
+		fs        = flag.NewFlagSet(parent.Name()+" passwd", flag.ContinueOnError)
+		algorithm = server.HashBcrypt
+		user      string
+		password  string
+		hash      string
+	)
+
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage:\n")
+		fmt.Fprintf(fs.Output(), "%s [OPTIONS] [PASSWORD]\n\n", fs.Name())
+		fmt.Fprintf(fs.Output(), "If PASSWORD is not set, reads it from STDIN.\n\nOptions:\n")
+		fs.PrintDefaults()
+	}
+
+	fs.StringVar(&algorithm, "algorithm", algorithm, "The hash algorithm: bcrypt, sha256 or sha512")
+	fs.StringVar(&user, "user", user, "If set, prints as htpasswd line USER:HASH")
+
+	if err = fs.Parse(args); err != nil {
+		if err.Error() == "flag: help requested" {
+			err = nil
+		}
+		return
+	}
+
+	if args = fs.Args(); len(args) > 0 {
+		password = args[0]
+	} else {
+		if stat, _ := os.Stdin.Stat(); stat != nil && stat.Mode()&os.ModeCharDevice != 0 {
+			fmt.Fprint(os.Stderr, "Password: ")
+		}
+		if password, err = bufio.NewReader(os.Stdin).ReadString('\n'); err != nil && err != io.EOF {
+			return
+		}
+		password = strings.TrimRight(password, "\r\n")
+	}
+
+	if password == "" {
+		return errors.New("password is blank")
+	}
+
+	if hash, err = server.HashPassword(algorithm, password); err != nil {
+		return
+	}
+
+	if user != "" {
+		hash = user + ":" + hash
+	}
+
+	fmt.Println(hash)
+	return nil
+}
+
 func runAbout(parent *flag.FlagSet, args []string) (err error) {
 	var (
 		fs     = flag.NewFlagSet(parent.Name()+" info", flag.ContinueOnError)
@@ -0,0 +1,245 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	AccessLogCombined = "combined"
+	AccessLogJSON     = "json"
+)
+
+type AccessLogConfig struct {
+	// Format is the log format: combined (Apache combined, default) or json
+	// (JSON lines).
+	Format string `yaml:"format"`
+	// Output is the log file path, or "-" to write to STDOUT (default).
+	// The file is reopened on SIGUSR1.
+	Output   string `yaml:"output"`
+	Disabled bool   `yaml:"disabled"`
+}
+
+// accessLog writes the records of HTTP requests and tunnel sessions.
+type accessLog struct {
+	mu     sync.Mutex
+	format string
+	output string
+	w      io.Writer
+	f      *os.File
+}
+
+func newAccessLog(cfg *AccessLogConfig) (l *accessLog, err error) {
+	l = &accessLog{format: cfg.Format, output: cfg.Output}
+
+	switch l.format {
+	case "":
+		l.format = AccessLogCombined
+	case AccessLogCombined, AccessLogJSON:
+	default:
+		return nil, fmt.Errorf("bad access log format %q", cfg.Format)
+	}
+
+	switch l.output {
+	case "", "-":
+		l.w = os.Stdout
+	default:
+		if err = l.Reopen(); err != nil {
+			return nil, err
+		}
+	}
+	return
+}
+
+// Reopen reopens the output file, used after log rotation.
+func (l *accessLog) Reopen() error {
+	if l.w == os.Stdout {
+		return nil
+	}
+
+	f, err := os.OpenFile(l.output, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return fmt.Errorf("open access log %q: %s", l.output, err)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.f != nil {
+		l.f.Close()
+	}
+	l.f, l.w = f, f
+	return nil
+}
+
+// currentAccessLog is the access log of current routes.
+var currentAccessLog atomic.Pointer[accessLog]
+
+func (l *accessLog) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.f != nil {
+		l.f.Close()
+		l.f = nil
+		l.w = io.Discard
+	}
+	return nil
+}
+
+func (l *accessLog) write(line []byte) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if _, err := l.w.Write(line); err != nil {
+		log.Printf("access log: %s", err)
+	}
+}
+
+func (l *accessLog) writeJSON(v any) {
+	line, _ := json.Marshal(v)
+	l.write(append(line, '\n'))
+}
+
+type requestRecord struct {
+	Type       string    `json:"type"`
+	Time       time.Time `json:"time"`
+	RequestID  string    `json:"request_id"`
+	RemoteAddr string    `json:"remote_addr"`
+	User       string    `json:"user,omitempty"`
+	Host       string    `json:"host"`
+	Method     string    `json:"method"`
+	URI        string    `json:"uri"`
+	Proto      string    `json:"proto"`
+	Status     int       `json:"status"`
+	Bytes      int64     `json:"bytes"`
+	Referer    string    `json:"referer,omitempty"`
+	UserAgent  string    `json:"user_agent,omitempty"`
+	Route      string    `json:"route"`
+	Duration   float64   `json:"duration"`
+}
+
+func (l *accessLog) logRequest(r *http.Request, info *requestInfo, status int, size int64) {
+	rec := requestRecord{
+		Type:       "http",
+		Time:       info.Start,
+		RequestID:  info.ID,
+		RemoteAddr: clientIP(r),
+		User:       info.User,
+		Host:       r.Host,
+		Method:     r.Method,
+		URI:        r.RequestURI,
+		Proto:      r.Proto,
+		Status:     status,
+		Bytes:      size,
+		Referer:    r.Referer(),
+		UserAgent:  r.UserAgent(),
+		Route:      info.Route,
+		Duration:   time.Since(info.Start).Seconds(),
+	}
+
+	if l.format == AccessLogJSON {
+		l.writeJSON(rec)
+		return
+	}
+
+	l.write([]byte(fmt.Sprintf("%s - %s [%s] %s %d %s %s %s\n",
+		rec.RemoteAddr,
+		orDash(rec.User),
+		rec.Time.Format("02/Jan/2006:15:04:05 -0700"),
+		strconv.Quote(rec.Method+" "+rec.URI+" "+rec.Proto),
+		rec.Status,
+		orDash(sizeString(rec.Bytes)),
+		strconv.Quote(orDash(rec.Referer)),
+		strconv.Quote(orDash(rec.UserAgent)),
+	)))
+}
+
+// tunnelSession is the record of a tunnel session.
+type tunnelSession struct {
+	Type       string    `json:"type"`
+	Time       time.Time `json:"time"`
+	Route      string    `json:"route"`
+	User       string    `json:"user,omitempty"`
+	RemoteAddr string    `json:"remote_addr"`
+	// BytesIn is the bytes sent from client to upstream.
+	BytesIn int64 `json:"bytes_in"`
+	// BytesOut is the bytes sent from upstream to client.
+	BytesOut int64   `json:"bytes_out"`
+	Duration float64 `json:"duration"`
+	Reason   string  `json:"reason"`
+}
+
+func (l *accessLog) logTunnel(s *tunnelSession) {
+	s.Type = "tunnel"
+	s.Duration = time.Since(s.Time).Seconds()
+
+	if l.format == AccessLogJSON {
+		l.writeJSON(s)
+		return
+	}
+
+	l.write([]byte(fmt.Sprintf("%s - %s [%s] %s %s %d %d %.3f\n",
+		s.RemoteAddr,
+		orDash(s.User),
+		s.Time.Format("02/Jan/2006:15:04:05 -0700"),
+		strconv.Quote("TUNNEL "+s.Route),
+		strconv.Quote(s.Reason),
+		s.BytesIn,
+		s.BytesOut,
+		s.Duration,
+	)))
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+func sizeString(size int64) string {
+	if size == 0 {
+		return ""
+	}
+	return strconv.FormatInt(size, 10)
+}
+
+// statusWriter records the status and size of response.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+	size   int64
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	if w.status == 0 && code >= 200 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusWriter) Write(p []byte) (n int, err error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err = w.ResponseWriter.Write(p)
+	w.size += int64(n)
+	return
+}
+
+func (w *statusWriter) Flush() {
+	http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
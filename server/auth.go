@@ -0,0 +1,81 @@
+package server
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// Check reports whether user and password matches.
+func (c *AuthConfig) Check(user, password string) bool {
+	if c.htpasswd != nil {
+		if c.htpasswd.Check(user, password) {
+			return true
+		}
+		if c.User == "" {
+			return false
+		}
+	}
+
+	// Calculate SHA-256 hashes for the provided and expected
+	// usernames.
+	usernameHash := sha256.Sum256([]byte(user))
+	expectedUsernameHash := sha256.Sum256([]byte(c.User))
+
+	usernameMatch := (subtle.ConstantTimeCompare(usernameHash[:], expectedUsernameHash[:]) == 1)
+	passwordMatch := checkPassword(c.Password, password)
+	return usernameMatch && passwordMatch
+}
+
+// setupAuth loads the htpasswd file of cfg, if any.
+func (rts *routes) setupAuth(cfg *AuthConfig) (err error) {
+	if cfg == nil || cfg.Disabled || cfg.HtpasswdFile == "" || cfg.htpasswd != nil {
+		return
+	}
+	if cfg.htpasswd, err = loadHtpasswd(cfg.HtpasswdFile); err != nil {
+		return fmt.Errorf("htpasswd: %s", err)
+	}
+	rts.closers = append(rts.closers, cfg.htpasswd)
+	return
+}
+
+// basicAuth requires the HTTP Basic authentication of cfg to serve next.
+// The authenticated user is forwarded to next in the header cfg.UserHeader.
+func basicAuth(cfg *AuthConfig, next http.Handler) http.Handler {
+	var (
+		realm      = cfg.Realm
+		userHeader = cfg.UserHeader
+	)
+
+	if realm == "" {
+		realm = "httpdx"
+	}
+	if userHeader == "" {
+		userHeader = "X-Forwarded-User"
+	}
+
+	challenge := "Basic realm=" + strconv.Quote(realm) + `, charset="UTF-8"`
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, password, ok := r.BasicAuth()
+		if !ok || !cfg.Check(user, password) {
+			if info := getRequestInfo(r); info != nil {
+				metrics.authFailures.Inc("http", info.Route)
+			}
+			w.Header().Set("WWW-Authenticate", challenge)
+			writeError(w, r, http.StatusUnauthorized, "Unauthorized")
+			return
+		}
+
+		if info := getRequestInfo(r); info != nil {
+			info.User = user
+		}
+		r.Header.Set(userHeader, user)
+		if !cfg.ForwardAuthorization {
+			r.Header.Del("Authorization")
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,252 @@
+package server
+
+import (
+	"fmt"
+	"hash/fnv"
+	"log"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	BalanceRoundRobin = "round_robin"
+	BalanceLeastConn  = "least_conn"
+	BalanceRandom     = "random"
+	BalanceIPHash     = "ip_hash"
+)
+
+type HealthCheckConfig struct {
+	// Path is the request path of probe (default is "/").
+	Path string `yaml:"path"`
+	// Interval between probes in seconds (default is 10s).
+	Interval uint8 `yaml:"interval"`
+	// Timeout of probe in seconds (default is 5s).
+	Timeout uint8 `yaml:"timeout"`
+	// UnhealthyThreshold is the number of consecutive failed probes to take
+	// the upstream out of rotation (default is 3).
+	UnhealthyThreshold uint8 `yaml:"unhealthy_threshold"`
+	// HealthyThreshold is the number of consecutive successful probes to put
+	// the upstream back into rotation (default is 2).
+	HealthyThreshold uint8 `yaml:"healthy_threshold"`
+	Disabled         bool  `yaml:"disabled"`
+}
+
+func (c *HealthCheckConfig) Defaults() {
+	if c.Path == "" {
+		c.Path = "/"
+	}
+	if c.Interval == 0 {
+		c.Interval = 10
+	}
+	if c.Timeout == 0 {
+		c.Timeout = 5
+	}
+	if c.UnhealthyThreshold == 0 {
+		c.UnhealthyThreshold = 3
+	}
+	if c.HealthyThreshold == 0 {
+		c.HealthyThreshold = 2
+	}
+}
+
+type upstream struct {
+	addr      string
+	url       *url.URL
+	transport http.RoundTripper
+	proxy     http.Handler
+	healthy   atomic.Bool
+	active    atomic.Int64
+
+	// fails and passes are owned by the health checker.
+	fails, passes uint8
+}
+
+// upstreamPool load balances requests between upstreams.
+type upstreamPool struct {
+	name      string
+	balance   string
+	upstreams []*upstream
+	retry     *retryPolicy
+	sticky    *stickiness
+	next      atomic.Uint64
+	stop      chan struct{}
+	closeOnce sync.Once
+}
+
+func newUpstreamPool(name, balance string, upstreams []*upstream) (*upstreamPool, error) {
+	switch balance {
+	case "":
+		balance = BalanceRoundRobin
+	case BalanceRoundRobin, BalanceLeastConn, BalanceRandom, BalanceIPHash:
+	default:
+		return nil, fmt.Errorf("bad balance %q", balance)
+	}
+
+	for _, u := range upstreams {
+		u.healthy.Store(true)
+	}
+
+	return &upstreamPool{
+		name:      name,
+		balance:   balance,
+		upstreams: upstreams,
+		stop:      make(chan struct{}),
+	}, nil
+}
+
+func (p *upstreamPool) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if p.retry.retryable(r) {
+		p.serveWithRetries(w, r)
+		return
+	}
+
+	u := p.pick(r, nil)
+	if u == nil {
+		writeError(w, r, http.StatusServiceUnavailable, "no healthy upstream")
+		return
+	}
+
+	p.sticky.pin(w, r, u)
+	u.active.Add(1)
+	defer u.active.Add(-1)
+	u.proxy.ServeHTTP(w, r)
+}
+
+// healthyUpstreams returns the healthy upstreams, except the skip ones.
+func (p *upstreamPool) healthyUpstreams(skip []*upstream) (healthy []*upstream) {
+	for _, u := range p.upstreams {
+		if u.healthy.Load() && !slices.Contains(skip, u) {
+			healthy = append(healthy, u)
+		}
+	}
+	return
+}
+
+// hashPick picks the healthy upstream of hash of key, except the skip ones.
+func (p *upstreamPool) hashPick(key string, skip []*upstream) *upstream {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	start := int(h.Sum32() % uint32(len(p.upstreams)))
+
+	// walk from the hashed upstream to keep the other clients pinned
+	// when one upstream goes down.
+	for i := range p.upstreams {
+		if u := p.upstreams[(start+i)%len(p.upstreams)]; u.healthy.Load() && !slices.Contains(skip, u) {
+			return u
+		}
+	}
+	return nil
+}
+
+// pick picks a healthy upstream to serve r, except the skip ones. The upstream
+// pinned to the client, if any, is preferred.
+func (p *upstreamPool) pick(r *http.Request, skip []*upstream) *upstream {
+	if u := p.sticky.pinned(r, p, skip); u != nil {
+		return u
+	}
+
+	if p.balance == BalanceIPHash {
+		return p.hashPick(clientIP(r), skip)
+	}
+
+	healthy := p.healthyUpstreams(skip)
+	if len(healthy) == 0 {
+		return nil
+	}
+
+	switch p.balance {
+	case BalanceLeastConn:
+		var (
+			best   *upstream
+			offset = p.next.Add(1)
+		)
+		for i := range healthy {
+			u := healthy[(offset+uint64(i))%uint64(len(healthy))]
+			if best == nil || u.active.Load() < best.active.Load() {
+				best = u
+			}
+		}
+		return best
+	case BalanceRandom:
+		return healthy[rand.Intn(len(healthy))]
+	default:
+		return healthy[(p.next.Add(1)-1)%uint64(len(healthy))]
+	}
+}
+
+// healthCheck probes the upstreams until the pool is closed.
+func (p *upstreamPool) healthCheck(cfg *HealthCheckConfig) {
+	cfg.Defaults()
+
+	var (
+		clients = map[*upstream]*http.Client{}
+		ticker  = time.NewTicker(time.Second * time.Duration(cfg.Interval))
+	)
+
+	defer ticker.Stop()
+
+	for _, u := range p.upstreams {
+		clients[u] = &http.Client{
+			Transport: u.transport,
+			Timeout:   time.Second * time.Duration(cfg.Timeout),
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		}
+	}
+
+	for {
+		for _, u := range p.upstreams {
+			p.probe(clients[u], cfg, u)
+		}
+
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (p *upstreamPool) probe(client *http.Client, cfg *HealthCheckConfig, u *upstream) {
+	var reason string
+
+	resp, err := client.Get(strings.TrimRight(u.url.String(), "/") + cfg.Path)
+	if err != nil {
+		reason = err.Error()
+	} else {
+		resp.Body.Close()
+		if resp.StatusCode < 200 || resp.StatusCode >= 400 {
+			reason = "status " + resp.Status
+		}
+	}
+
+	if reason == "" {
+		u.fails = 0
+		if u.passes++; !u.healthy.Load() && u.passes >= cfg.HealthyThreshold {
+			u.healthy.Store(true)
+			log.Printf("HTTP %q: upstream %s is UP", p.name, u.addr)
+		}
+		return
+	}
+
+	u.passes = 0
+	if u.fails++; u.healthy.Load() && u.fails >= cfg.UnhealthyThreshold {
+		u.healthy.Store(false)
+		log.Printf("HTTP %q: upstream %s is DOWN: %s", p.name, u.addr, reason)
+	}
+}
+
+// Close stops the health checker.
+func (p *upstreamPool) Close() error {
+	p.closeOnce.Do(func() {
+		close(p.stop)
+	})
+	return nil
+}
@@ -0,0 +1,277 @@
+package server
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+)
+
+const (
+	EncodingBrotli = "br"
+	EncodingGzip   = "gzip"
+)
+
+var defaultCompressionTypes = []string{
+	"text/*",
+	"application/javascript",
+	"application/json",
+	"application/manifest+json",
+	"application/xml",
+	"application/xhtml+xml",
+	"application/rss+xml",
+	"application/atom+xml",
+	"application/wasm",
+	"image/svg+xml",
+}
+
+type CompressionConfig struct {
+	// Encodings is the encodings in order of preference: br and gzip
+	// (default is both).
+	Encodings []string `yaml:"encodings"`
+	// Types is the compressed MIME types. Accepts wildcards like text/*
+	// (default is text/*, JSON, JavaScript, XML, WebAssembly and SVG types).
+	Types []string `yaml:"types"`
+	// MinSize is the minimum response size in bytes to compress (default is
+	// 1024).
+	MinSize  int  `yaml:"min_size"`
+	Disabled bool `yaml:"disabled"`
+}
+
+func (c *CompressionConfig) encodings() []string {
+	if len(c.Encodings) == 0 {
+		return []string{EncodingBrotli, EncodingGzip}
+	}
+	return c.Encodings
+}
+
+// acceptedEncodings returns the encodings accepted by the Accept-Encoding
+// header of r, in order of preference.
+func acceptedEncodings(r *http.Request, encodings []string) (accepted []string) {
+	values := map[string]float64{}
+
+	for _, part := range strings.Split(strings.Join(r.Header.Values("Accept-Encoding"), ","), ",") {
+		name, params, _ := strings.Cut(part, ";")
+		if name = strings.ToLower(strings.TrimSpace(name)); name == "" {
+			continue
+		}
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			q, _ = strconv.ParseFloat(v, 64)
+		}
+		values[name] = q
+	}
+
+	for _, enc := range encodings {
+		q, ok := values[enc]
+		if !ok {
+			q, ok = values["*"]
+		}
+		if ok && q > 0 {
+			accepted = append(accepted, enc)
+		}
+	}
+	return
+}
+
+type encoder interface {
+	io.WriteCloser
+	Flush() error
+}
+
+func newEncoder(enc string, w io.Writer) encoder {
+	if enc == EncodingBrotli {
+		return brotli.NewWriter(w)
+	}
+	return gzip.NewWriter(w)
+}
+
+// compressionHandler compresses the responses of next.
+func compressionHandler(cfg *CompressionConfig, next http.Handler) (http.Handler, error) {
+	var (
+		encodings = cfg.encodings()
+		types     = cfg.Types
+		minSize   = cfg.MinSize
+	)
+
+	for _, enc := range encodings {
+		if enc != EncodingBrotli && enc != EncodingGzip {
+			return nil, fmt.Errorf("compression: bad encoding %q", enc)
+		}
+	}
+
+	if len(types) == 0 {
+		types = defaultCompressionTypes
+	}
+	if minSize == 0 {
+		minSize = 1024
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead || r.Header.Get("Range") != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		cw := &compressWriter{ResponseWriter: w, types: types, minSize: minSize}
+		if accepted := acceptedEncodings(r, encodings); len(accepted) > 0 {
+			cw.encoding = accepted[0]
+		}
+
+		defer cw.Close()
+		next.ServeHTTP(cw, r)
+	}), nil
+}
+
+// compressWriter compresses the response if its type is allowed and its size
+// reaches minSize. While the size is unknown, the body is buffered.
+type compressWriter struct {
+	http.ResponseWriter
+	// encoding is the negotiated encoding. If blank, only the Vary header is
+	// added.
+	encoding string
+	types    []string
+	minSize  int
+
+	status      int
+	wroteHeader bool
+	bypass      bool
+	buf         []byte
+	enc         encoder
+}
+
+// compressible reports whether the response can be compressed.
+func (w *compressWriter) compressible() bool {
+	h := w.Header()
+
+	switch {
+	case w.status == http.StatusNoContent, w.status == http.StatusNotModified,
+		w.status == http.StatusPartialContent,
+		h.Get("Content-Encoding") != "", h.Get("Content-Range") != "",
+		strings.Contains(h.Get("Cache-Control"), "no-transform"):
+		return false
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(h.Get("Content-Type"))
+	for _, t := range w.types {
+		if t == mediaType || (strings.HasSuffix(t, "/*") && strings.HasPrefix(mediaType, t[:len(t)-1])) {
+			return true
+		}
+	}
+	return false
+}
+
+func (w *compressWriter) WriteHeader(code int) {
+	if code < 200 {
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+	if w.wroteHeader {
+		return
+	}
+
+	w.wroteHeader = true
+	w.status = code
+
+	if !w.compressible() {
+		w.skip()
+		return
+	}
+
+	w.Header().Add("Vary", "Accept-Encoding")
+
+	if w.encoding == "" {
+		w.skip()
+		return
+	}
+
+	if s := w.Header().Get("Content-Length"); s != "" {
+		if size, err := strconv.Atoi(s); err == nil && size < w.minSize {
+			w.skip()
+			return
+		}
+		w.start()
+	}
+}
+
+// skip writes the header without compression.
+func (w *compressWriter) skip() {
+	w.bypass = true
+	w.ResponseWriter.WriteHeader(w.status)
+}
+
+// start writes the header and starts the compression.
+func (w *compressWriter) start() {
+	h := w.Header()
+	h.Del("Content-Length")
+	h.Del("Accept-Ranges")
+	h.Set("Content-Encoding", w.encoding)
+	if etag := h.Get("ETag"); strings.HasPrefix(etag, `"`) {
+		h.Set("ETag", "W/"+etag)
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+	w.enc = newEncoder(w.encoding, w.ResponseWriter)
+}
+
+func (w *compressWriter) Write(p []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+
+	switch {
+	case w.bypass:
+		return w.ResponseWriter.Write(p)
+	case w.enc != nil:
+		return w.enc.Write(p)
+	}
+
+	w.buf = append(w.buf, p...)
+	if len(w.buf) >= w.minSize {
+		w.start()
+		if _, err := w.enc.Write(w.buf); err != nil {
+			return 0, err
+		}
+		w.buf = nil
+	}
+	return len(p), nil
+}
+
+// Close writes the buffered body or finishes the compression.
+func (w *compressWriter) Close() error {
+	switch {
+	case !w.wroteHeader, w.bypass:
+		return nil
+	case w.enc != nil:
+		return w.enc.Close()
+	}
+
+	w.Header().Set("Content-Length", strconv.Itoa(len(w.buf)))
+	w.skip()
+	_, err := w.ResponseWriter.Write(w.buf)
+	return err
+}
+
+func (w *compressWriter) Flush() {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.wroteHeader && !w.bypass {
+		// streamed responses are compressed whatever the size.
+		if w.enc == nil {
+			w.start()
+			w.enc.Write(w.buf)
+			w.buf = nil
+		}
+		w.enc.Flush()
+	}
+	http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+func (w *compressWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
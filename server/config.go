@@ -3,9 +3,12 @@ package server
 import (
 	"fmt"
 	"strconv"
+	"strings"
 )
 
 type HttpConfig struct {
+	// Addr is the upstream address: "host:port", "unix:/path/to.sock" or a
+	// URL with scheme and optional base path, like "https://host:port/base".
 	Addr       string `yaml:"addr"`
 	PathStrip  bool   `yaml:"path_strip"`
 	PathHeader string `yaml:"path_header"`
@@ -16,44 +19,202 @@ type HttpConfig struct {
 	// 2. The DIR
 	// 3. The route PATH
 	PathOverride string `yaml:"path_override"`
-	Disabled     bool   `yaml:"disabled"`
+	// Static is the options of static files serving of Dir.
+	Static *StaticConfig `yaml:"static"`
+	// Upstreams is the addresses to load balance. Addr, if set, is the first upstream.
+	Upstreams []string `yaml:"upstreams"`
+	// Balance is the load balancing method of upstreams: round_robin (default),
+	// least_conn, random or ip_hash.
+	Balance     string             `yaml:"balance"`
+	HealthCheck *HealthCheckConfig `yaml:"health_check"`
+	// Sticky pins the clients to an upstream, by cookie, header or IP hash.
+	Sticky *StickyConfig `yaml:"sticky"`
+	// Timeouts is the route timeouts, over the server timeouts.
+	Timeouts *TimeoutsConfig `yaml:"timeouts"`
+	// MaxBodySize is the maximum request body size in bytes. If zero, uses
+	// the server value. If negative, it is unlimited.
+	MaxBodySize int64 `yaml:"max_body_size"`
+	// Retry is the retries of failed upstream connections of idempotent
+	// requests, on the next healthy upstream.
+	Retry *RetryConfig `yaml:"retry"`
+	// Auth is the authentication of route. If not set, uses the default
+	// HTTP authentication.
+	Auth *AuthConfig `yaml:"auth"`
+	// UpstreamTLS is the TLS options of HTTPS upstreams.
+	UpstreamTLS *UpstreamTLSConfig `yaml:"upstream_tls"`
+	// Compression is the compression of responses. The static routes also
+	// serves the precompressed .br and .gz sibling files.
+	Compression *CompressionConfig `yaml:"compression"`
+	// Headers is the rules to rewrite the request and response headers.
+	Headers *HeadersConfig `yaml:"headers"`
+	// Maintenance is the maintenance state of route.
+	Maintenance *MaintenanceConfig `yaml:"maintenance"`
+	// Mirror copies the requests to shadow upstreams.
+	Mirror *MirrorConfig `yaml:"mirror"`
+	// CORS is the cross-origin resource sharing policy. The preflight
+	// requests are answered by httpdx.
+	CORS *CORSConfig `yaml:"cors"`
+	// Fallback is the handlers tried in order when the previous handler
+	// responds 404, like a static dir followed by an upstream. If all
+	// responds 404, the not found handler of server responds.
+	Fallback []*HttpConfig `yaml:"fallback"`
+	// ErrorPages is the HTML templates files of error responses by status
+	// code, over the server error pages.
+	ErrorPages map[int]string `yaml:"error_pages"`
+	// Rewrite is the rules to rewrite the request path, applied in order.
+	Rewrite []*RewriteConfig `yaml:"rewrite"`
+	// Redirect if set, the route redirects the requests instead of proxying.
+	Redirect *RedirectConfig `yaml:"redirect"`
+	// RateLimit is the requests per second limit of route by client.
+	RateLimit *RateLimitConfig `yaml:"rate_limit"`
+	// Allow is the allowed client CIDRs or IP addresses. If empty, all are
+	// allowed.
+	Allow []string `yaml:"allow"`
+	// Deny is the denied client CIDRs or IP addresses, evaluated before Allow.
+	Deny     []string `yaml:"deny"`
+	Disabled bool     `yaml:"disabled"`
+}
+
+// UpstreamAddrs returns Addr and Upstreams.
+func (c *HttpConfig) UpstreamAddrs() (addrs []string) {
+	if c.Addr != "" {
+		addrs = append(addrs, c.Addr)
+	}
+	return append(addrs, c.Upstreams...)
 }
 
 func (c *HttpConfig) ToString(dir string) string {
+	s := c.targetString(dir)
+	for _, fb := range c.Fallback {
+		s += " | FALLBACK " + fb.targetString(dir)
+	}
+	return s
+}
+
+func (c *HttpConfig) targetString(dir string) string {
+	if c.Redirect != nil {
+		code := c.Redirect.Code
+		if code == 0 {
+			code = 302
+		}
+		return fmt.Sprintf("REDIRECT %d %s", code, c.Redirect.To)
+	}
 	if c.Dir != "" {
 		return fmt.Sprintf("STATIC %s", c.Dir)
 	}
 
-	s := c.Addr
+	s := strings.Join(c.UpstreamAddrs(), ", ")
+	if len(c.Upstreams) > 0 {
+		balance := c.Balance
+		if balance == "" {
+			balance = BalanceRoundRobin
+		}
+		if c.Sticky != nil && !c.Sticky.Disabled {
+			mode := c.Sticky.Mode
+			if mode == "" {
+				mode = StickyCookie
+			}
+			balance += " sticky " + mode
+		}
+		s = balance + " {" + s + "}"
+	}
 	if c.PathStrip {
 		s += " [" + strconv.Quote(dir) + "]"
 	}
+	if c.Mirror != nil && !c.Mirror.Disabled {
+		s += " MIRROR {" + strings.Join(c.Mirror.Upstreams, ", ") + "}"
+	}
 	return s
 }
 
+type HttpHostConfig struct {
+	Routes   map[string]*HttpConfig `yaml:"routes"`
+	Disabled bool                   `yaml:"disabled"`
+}
+
+type HttpServerConfig struct {
+	// Routes is the routes of the default host.
+	Routes map[string]*HttpConfig `yaml:"routes"`
+	// Hosts is the routes by host name. The name is an exact host name or a
+	// wildcard like *.example.com.
+	Hosts map[string]*HttpHostConfig `yaml:"hosts"`
+	// DefaultHost if set, is the name of the host (of Hosts) used by
+	// requests that doesn't match any host. Otherwise, uses Routes.
+	DefaultHost string `yaml:"default_host"`
+	// Auth is the default authentication of routes.
+	Auth *AuthConfig `yaml:"auth"`
+	// RateLimit is the requests per second limit by client of all routes.
+	RateLimit *RateLimitConfig `yaml:"rate_limit"`
+	// Timeouts is the server timeouts, and the default timeouts of routes.
+	// The changes of server timeouts requires restart.
+	Timeouts *TimeoutsConfig `yaml:"timeouts"`
+	// MaxBodySize is the default maximum request body size in bytes of
+	// routes. If zero, it is unlimited.
+	MaxBodySize int64 `yaml:"max_body_size"`
+}
+
 type TCPSocketConfig struct {
-	Addr     string      `yaml:"addr"`
-	Auth     *AuthConfig `yaml:"auth"`
-	Disabled bool        `yaml:"disabled"`
+	Addr string `yaml:"addr"`
+	// Alternatives is the addresses dialed in order if Addr fails.
+	Alternatives []string    `yaml:"alternatives"`
+	Auth         *AuthConfig `yaml:"auth"`
+	// RateLimit is the new tunnels per minute limit of route by client.
+	RateLimit *RateLimitConfig `yaml:"rate_limit"`
+	// Allow is the allowed client CIDRs or IP addresses. If empty, all are
+	// allowed.
+	Allow []string `yaml:"allow"`
+	// Deny is the denied client CIDRs or IP addresses, evaluated before Allow.
+	Deny []string `yaml:"deny"`
+	// Maintenance is the maintenance state of route.
+	Maintenance *MaintenanceConfig `yaml:"maintenance"`
+	Disabled    bool               `yaml:"disabled"`
+
+	limiters    []*rateLimiter
+	acl         *accessList
+	maintenance *maintenance
+}
+
+// Addrs returns Addr and Alternatives.
+func (c *TCPSocketConfig) Addrs() []string {
+	return append([]string{c.Addr}, c.Alternatives...)
 }
 
 func (c *TCPSocketConfig) String() string {
+	if len(c.Alternatives) > 0 {
+		return c.Addr + " (" + strings.Join(c.Alternatives, ", ") + ")"
+	}
 	return c.Addr
 }
 
 type AuthConfig struct {
-	User     string `yaml:"user"`
+	User string `yaml:"user"`
+	// Password is the plaintext password or a hash: bcrypt ($2y$), SHA-crypt
+	// ($5$ or $6$), Apache MD5 ($apr1$) or {SHA}.
 	Password string `yaml:"password"`
-	Disabled bool   `yaml:"disabled"`
+	// HtpasswdFile is an htpasswd file with users, reloaded when changes.
+	HtpasswdFile string `yaml:"htpasswd_file"`
+	// Realm is the HTTP Basic authentication realm (default is "httpdx").
+	Realm string `yaml:"realm"`
+	// UserHeader is the request header that forwards the authenticated user
+	// to the HTTP upstream (default is "X-Forwarded-User").
+	UserHeader string `yaml:"user_header"`
+	// ForwardAuthorization if true, forwards the Authorization header to the
+	// HTTP upstream. Otherwise, it is removed after the authentication.
+	ForwardAuthorization bool `yaml:"forward_authorization"`
+	Disabled             bool `yaml:"disabled"`
+
+	htpasswd *htpasswd
 }
 
 type TCPSocketsConfig struct {
-	HandshakeTimeout   uint8                       `yaml:"handshake_timeout"`
-	DialTimeout        uint8                       `yaml:"dial_timeout"`
-	WriteTimeout       uint8                       `yaml:"write_timeout"`
-	CompressionEnabled bool                        `yaml:"compression_enabled"`
-	Auth               *AuthConfig                 `yaml:"auth"`
-	Routes             map[string]*TCPSocketConfig `yaml:"routes"`
+	HandshakeTimeout   uint8       `yaml:"handshake_timeout"`
+	DialTimeout        uint8       `yaml:"dial_timeout"`
+	WriteTimeout       uint8       `yaml:"write_timeout"`
+	CompressionEnabled bool        `yaml:"compression_enabled"`
+	Auth               *AuthConfig `yaml:"auth"`
+	// RateLimit is the new tunnels per minute limit by client of all routes.
+	RateLimit *RateLimitConfig            `yaml:"rate_limit"`
+	Routes    map[string]*TCPSocketConfig `yaml:"routes"`
 }
 
 func (c *TCPSocketsConfig) Defaults() {
@@ -69,13 +230,32 @@ func (c *TCPSocketsConfig) Defaults() {
 }
 
 type Config struct {
-	Addr string `yaml:"addr"`
+	Addr string     `yaml:"addr"`
+	TLS  *TLSConfig `yaml:"tls"`
 	// NotFound is a file path to handles unhandled requests.
 	NotFound string `yaml:"not_found"`
+	// ErrorPages is the HTML templates files of error responses by status
+	// code, like 404, 502, 503 and 504. The templates variables are:
+	// .Status, .StatusText, .Message, .Path, .RequestID, .Route, .Host,
+	// .Method, .ClientIP, .User, .Query, .URI and .Scheme.
+	ErrorPages map[int]string `yaml:"error_pages"`
 	// NotFoundDisabled if value is true, disables handle unhandled requests.
 	NotFoundDisabled bool             `yaml:"not_found_disabled"`
 	TCPSockets       TCPSocketsConfig `yaml:"tcp_sockets"`
-	HTTP             struct {
-		Routes map[string]*HttpConfig `yaml:"routes"`
-	} `yaml:"http"`
+	HTTP             HttpServerConfig `yaml:"http"`
+	Reload           ReloadConfig     `yaml:"reload"`
+	AccessLog        *AccessLogConfig `yaml:"access_log"`
+	Metrics          *MetricsConfig   `yaml:"metrics"`
+	// DrainTimeout is the time in seconds to wait for the active HTTP requests
+	// and tunnels on shutdown, before close them (default is 30s).
+	DrainTimeout uint8 `yaml:"drain_timeout"`
+	// Allow is the allowed client CIDRs or IP addresses of all HTTP requests
+	// and tunnels. If empty, all are allowed.
+	Allow []string `yaml:"allow"`
+	// Deny is the denied client CIDRs or IP addresses of all HTTP requests and
+	// tunnels, evaluated before Allow.
+	Deny []string `yaml:"deny"`
+	// TrustedProxies is the CIDRs or IP addresses of proxies trusted to set
+	// the client address in the X-Forwarded-For header.
+	TrustedProxies []string `yaml:"trusted_proxies"`
 }
@@ -0,0 +1,138 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+)
+
+type CORSConfig struct {
+	// Origins is the allowed origins, like "https://app.example.com". Accepts
+	// wildcards like "https://*.example.com", or "*" to allow any origin.
+	Origins []string `yaml:"origins"`
+	// Methods is the allowed methods (default is GET, HEAD and POST).
+	Methods []string `yaml:"methods"`
+	// Headers is the allowed request headers. If empty, allows the headers
+	// requested by the preflight.
+	Headers []string `yaml:"headers"`
+	// ExposedHeaders is the response headers readable by the client.
+	ExposedHeaders []string `yaml:"exposed_headers"`
+	// Credentials if true, allows cookies and authentication. Not allowed with
+	// the "*" origin.
+	Credentials bool `yaml:"credentials"`
+	// MaxAge is the time in seconds the preflight response can be cached.
+	MaxAge   uint32 `yaml:"max_age"`
+	Disabled bool   `yaml:"disabled"`
+}
+
+func (c *CORSConfig) Defaults() {
+	if len(c.Methods) == 0 {
+		c.Methods = []string{"GET", "HEAD", "POST"}
+	}
+}
+
+// AllowOrigin reports whether origin is allowed.
+func (c *CORSConfig) AllowOrigin(origin string) bool {
+	for _, o := range c.Origins {
+		if o == "*" || strings.EqualFold(o, origin) {
+			return true
+		}
+		if ok, _ := path.Match(strings.ToLower(o), strings.ToLower(origin)); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// corsHandler answers the preflight requests and sets the CORS headers of
+// the responses of next. The CORS headers of next are replaced.
+func corsHandler(cfg *CORSConfig, next http.Handler) (http.Handler, error) {
+	cfg.Defaults()
+
+	for _, o := range cfg.Origins {
+		if _, err := path.Match(o, ""); err != nil {
+			return nil, fmt.Errorf("cors: bad origin %q", o)
+		}
+		if o == "*" && cfg.Credentials {
+			return nil, fmt.Errorf("cors: credentials aren't allowed with the \"*\" origin")
+		}
+	}
+
+	var (
+		anyOrigin = len(cfg.Origins) == 1 && cfg.Origins[0] == "*"
+		methods   = strings.ToUpper(strings.Join(cfg.Methods, ", "))
+		headers   = strings.Join(cfg.Headers, ", ")
+		exposed   = strings.Join(cfg.ExposedHeaders, ", ")
+		maxAge    = strconv.Itoa(int(cfg.MaxAge))
+	)
+
+	setOrigin := func(h http.Header, origin string) {
+		removeCORSHeaders(h)
+		if anyOrigin {
+			h.Set("Access-Control-Allow-Origin", "*")
+		} else {
+			h.Set("Access-Control-Allow-Origin", origin)
+			h.Add("Vary", "Origin")
+		}
+		if cfg.Credentials {
+			h.Set("Access-Control-Allow-Credentials", "true")
+		}
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		allowed := cfg.AllowOrigin(origin)
+
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			if !allowed {
+				writeError(w, r, http.StatusForbidden, "CORS origin not allowed")
+				return
+			}
+
+			h := w.Header()
+			setOrigin(h, origin)
+			h.Add("Vary", "Access-Control-Request-Method")
+			h.Add("Vary", "Access-Control-Request-Headers")
+			h.Set("Access-Control-Allow-Methods", methods)
+			if headers != "" {
+				h.Set("Access-Control-Allow-Headers", headers)
+			} else if requested := r.Header.Get("Access-Control-Request-Headers"); requested != "" {
+				h.Set("Access-Control-Allow-Headers", requested)
+			}
+			if cfg.MaxAge > 0 {
+				h.Set("Access-Control-Max-Age", maxAge)
+			}
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		w = &headerWriter{ResponseWriter: w, apply: func(h http.Header) {
+			if !allowed {
+				removeCORSHeaders(h)
+				return
+			}
+			setOrigin(h, origin)
+			if exposed != "" {
+				h.Set("Access-Control-Expose-Headers", exposed)
+			}
+		}}
+
+		next.ServeHTTP(w, r)
+	}), nil
+}
+
+// removeCORSHeaders removes the CORS headers of h.
+func removeCORSHeaders(h http.Header) {
+	for k := range h {
+		if strings.HasPrefix(k, "Access-Control-") {
+			delete(h, k)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+const errorPagesKey contextKey = "errorPages"
+
+// errorPages is the error page templates by status code.
+type errorPages map[int]*template.Template
+
+// errorPageVars is the variables of error page templates.
+type errorPageVars struct {
+	*requestVars
+	Status     int
+	StatusText string
+	// Message is the error message.
+	Message string
+}
+
+var fallbackTemplate = template.Must(template.New("fallback").Parse(fallbackPage))
+
+// loadErrorPages loads the templates files by status code, over the pages
+// of parent.
+func loadErrorPages(files map[int]string, parent errorPages) (pages errorPages, err error) {
+	if len(files) == 0 {
+		return parent, nil
+	}
+
+	pages = errorPages{}
+	for code, t := range parent {
+		pages[code] = t
+	}
+
+	for code, file := range files {
+		if code < 400 || code > 599 {
+			return nil, fmt.Errorf("error page %q: bad status code %d", file, code)
+		}
+
+		var data []byte
+		if data, err = os.ReadFile(file); err != nil {
+			return nil, fmt.Errorf("error page: %s", err)
+		}
+		if pages[code], err = template.New(filepath.Base(file)).Parse(string(data)); err != nil {
+			return nil, fmt.Errorf("error page: %s", err)
+		}
+	}
+	return
+}
+
+// errorPagesHandler serves next with the error pages.
+func errorPagesHandler(pages errorPages, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), errorPagesKey, pages)))
+	})
+}
+
+// requestErrorPages returns the error pages of request.
+func requestErrorPages(r *http.Request) errorPages {
+	pages, _ := r.Context().Value(errorPagesKey).(errorPages)
+	return pages
+}
+
+// writeError responds the error status code by the error page of request,
+// or the plain text msg if there is no page.
+func writeError(w http.ResponseWriter, r *http.Request, code int, msg string) {
+	writeErrorPage(w, r, code, msg, nil)
+}
+
+// writeErrorPage responds the error status code by the error page of request,
+// or by def template if there is no page. If both are nil, responds the
+// plain text msg.
+func writeErrorPage(w http.ResponseWriter, r *http.Request, code int, msg string, def *template.Template) {
+	t := requestErrorPages(r)[code]
+	if t == nil {
+		t = def
+	}
+	renderErrorPage(w, r, code, msg, t)
+}
+
+// renderErrorPage responds the error status code by the template t. If t is
+// nil, responds the plain text msg.
+func renderErrorPage(w http.ResponseWriter, r *http.Request, code int, msg string, t *template.Template) {
+	if t != nil {
+		var (
+			b    bytes.Buffer
+			vars = &errorPageVars{newRequestVars(r), code, http.StatusText(code), msg}
+		)
+		err := t.Execute(&b, vars)
+		if err == nil {
+			h := w.Header()
+			h.Del("Content-Length")
+			h.Set("Content-Type", "text/html; charset=utf-8")
+			h.Set("X-Content-Type-Options", "nosniff")
+			w.WriteHeader(code)
+			w.Write(b.Bytes())
+			return
+		}
+		log.Printf("error page %d: %s", code, err)
+	}
+
+	http.Error(w, msg, code)
+}
+
+// errorPageWriter replaces the error responses that have error page.
+type errorPageWriter struct {
+	http.ResponseWriter
+	r           *http.Request
+	pages       errorPages
+	intercepted bool
+}
+
+func (w *errorPageWriter) WriteHeader(code int) {
+	if w.intercepted {
+		return
+	}
+	if w.pages[code] != nil {
+		w.intercepted = true
+		writeError(w.ResponseWriter, w.r, code, http.StatusText(code))
+		return
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *errorPageWriter) Write(p []byte) (int, error) {
+	if w.intercepted {
+		return len(p), nil
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *errorPageWriter) Flush() {
+	http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+func (w *errorPageWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
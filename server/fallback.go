@@ -0,0 +1,136 @@
+package server
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+)
+
+// Handlers is a chain of handlers. Each handler serves the request when the
+// previous handler responds 404 or doesn't respond. The last handler
+// response is always sent. The request header
+// "X-Httpdx-Handle-Fallback: false" disables the chain, so only the first
+// handler serves.
+//
+// Request bodies up to 1MB are replayed to the next handlers. If the body is
+// larger, only the first handler serves.
+type Handlers []http.Handler
+
+func (h Handlers) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if len(h) == 0 {
+		return
+	}
+	if len(h) == 1 || r.Header.Get("X-Httpdx-Handle-Fallback") == "false" {
+		h[0].ServeHTTP(w, r)
+		return
+	}
+
+	var body []byte
+	if r.Body != nil && r.Body != http.NoBody {
+		if r.ContentLength > retryBodyLimit {
+			h[0].ServeHTTP(w, r)
+			return
+		}
+
+		var err error
+		if body, err = io.ReadAll(io.LimitReader(r.Body, retryBodyLimit+1)); err != nil {
+			code := bodyErrorStatus(err)
+			writeError(w, r, code, http.StatusText(code))
+			return
+		}
+		if len(body) > retryBodyLimit {
+			r.Body = struct {
+				io.Reader
+				io.Closer
+			}{io.MultiReader(bytes.NewReader(body), r.Body), r.Body}
+			h[0].ServeHTTP(w, r)
+			return
+		}
+	}
+
+	last := len(h) - 1
+	for i, handler := range h {
+		// each handler gets its own request, because a handler may keep it
+		// after returning (e.g. the mirror).
+		r2 := *r
+		if body != nil {
+			r2.Body = io.NopCloser(bytes.NewReader(body))
+		}
+		if i == last {
+			handler.ServeHTTP(w, &r2)
+			return
+		}
+
+		fw := &fallbackWriter{ResponseWriter: w, header: w.Header().Clone()}
+		handler.ServeHTTP(fw, &r2)
+		if fw.wroteHeader && !fw.notFound {
+			return
+		}
+	}
+}
+
+// fallbackWriter discards the 404 response, to try the next handler. The
+// other responses are passed through.
+type fallbackWriter struct {
+	http.ResponseWriter
+	header      http.Header
+	wroteHeader bool
+	notFound    bool
+}
+
+func (w *fallbackWriter) Header() http.Header {
+	if w.wroteHeader && !w.notFound {
+		// the trailers are set after the header is written
+		return w.ResponseWriter.Header()
+	}
+	return w.header
+}
+
+func (w *fallbackWriter) WriteHeader(code int) {
+	if w.wroteHeader {
+		return
+	}
+	if code == http.StatusNotFound {
+		w.wroteHeader, w.notFound = true, true
+		return
+	}
+
+	h := w.ResponseWriter.Header()
+	for k := range h {
+		if _, ok := w.header[k]; !ok {
+			delete(h, k)
+		}
+	}
+	for k, v := range w.header {
+		h[k] = v
+	}
+
+	// informational responses are followed by the final response
+	if code >= 200 || code == http.StatusSwitchingProtocols {
+		w.wroteHeader = true
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *fallbackWriter) Write(p []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.notFound {
+		return len(p), nil
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *fallbackWriter) Flush() {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if !w.notFound {
+		http.NewResponseController(w.ResponseWriter).Flush()
+	}
+}
+
+func (w *fallbackWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
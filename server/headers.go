@@ -0,0 +1,177 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+// HeaderRulesConfig is the rules to rewrite headers. The values are go
+// templates with the variables: .ClientIP, .Host, .Route, .User, .Method,
+// .Path, .Query, .URI, .Scheme and .RequestID.
+type HeaderRulesConfig struct {
+	// Set sets the header values.
+	Set map[string]string `yaml:"set"`
+	// Add adds the header values.
+	Add map[string]string `yaml:"add"`
+	// Remove removes the headers.
+	Remove []string `yaml:"remove"`
+}
+
+type HeadersConfig struct {
+	// Request is the rules applied to request before proxying.
+	Request *HeaderRulesConfig `yaml:"request"`
+	// Response is the rules applied to response before returning to the client.
+	Response *HeaderRulesConfig `yaml:"response"`
+}
+
+// valueTemplate is a value that may be a go template.
+type valueTemplate struct {
+	raw  string
+	tmpl *template.Template
+}
+
+func newValueTemplate(name, value string) (*valueTemplate, error) {
+	v := &valueTemplate{raw: value}
+	if strings.Contains(value, "{{") {
+		var err error
+		if v.tmpl, err = template.New(name).Option("missingkey=zero").Parse(value); err != nil {
+			return nil, err
+		}
+	}
+	return v, nil
+}
+
+func (v *valueTemplate) Execute(data any) string {
+	if v.tmpl == nil {
+		return v.raw
+	}
+	var b strings.Builder
+	if err := v.tmpl.Execute(&b, data); err != nil {
+		return ""
+	}
+	return b.String()
+}
+
+type headerValue struct {
+	name  string
+	value *valueTemplate
+}
+
+type headerRules struct {
+	set, add []headerValue
+	remove   []string
+}
+
+func newHeaderRules(cfg *HeaderRulesConfig) (rules *headerRules, err error) {
+	if cfg == nil {
+		return
+	}
+
+	rules = &headerRules{}
+
+	build := func(m map[string]string) (values []headerValue, err error) {
+		for name, value := range m {
+			var v *valueTemplate
+			if v, err = newValueTemplate(name, value); err != nil {
+				return nil, fmt.Errorf("header %q: %s", name, err)
+			}
+			values = append(values, headerValue{http.CanonicalHeaderKey(name), v})
+		}
+		sort.Slice(values, func(i, j int) bool {
+			return values[i].name < values[j].name
+		})
+		return
+	}
+
+	if rules.set, err = build(cfg.Set); err != nil {
+		return nil, err
+	}
+	if rules.add, err = build(cfg.Add); err != nil {
+		return nil, err
+	}
+	rules.remove = cfg.Remove
+	return
+}
+
+// Apply applies the rules to header h. If r is not nil, the Host rule sets
+// the request host.
+func (rules *headerRules) Apply(h http.Header, r *http.Request, vars *requestVars) {
+	for _, name := range rules.remove {
+		h.Del(name)
+	}
+	for _, v := range rules.set {
+		value := v.value.Execute(vars)
+		if r != nil && v.name == "Host" {
+			r.Host = value
+			continue
+		}
+		h.Set(v.name, value)
+	}
+	for _, v := range rules.add {
+		h.Add(v.name, v.value.Execute(vars))
+	}
+}
+
+// headersHandler rewrites the request and response headers of next.
+func headersHandler(cfg *HeadersConfig, next http.Handler) (_ http.Handler, err error) {
+	var requestRules, responseRules *headerRules
+
+	if requestRules, err = newHeaderRules(cfg.Request); err != nil {
+		return nil, fmt.Errorf("request headers: %s", err)
+	}
+	if responseRules, err = newHeaderRules(cfg.Response); err != nil {
+		return nil, fmt.Errorf("response headers: %s", err)
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		vars := newRequestVars(r)
+
+		if requestRules != nil {
+			requestRules.Apply(r.Header, r, vars)
+		}
+
+		if responseRules != nil {
+			w = &headerWriter{ResponseWriter: w, apply: func(h http.Header) {
+				responseRules.Apply(h, nil, vars)
+			}}
+		}
+
+		next.ServeHTTP(w, r)
+	}), nil
+}
+
+// headerWriter calls apply before writing the response header.
+type headerWriter struct {
+	http.ResponseWriter
+	apply   func(h http.Header)
+	applied bool
+}
+
+func (w *headerWriter) WriteHeader(code int) {
+	if !w.applied && code >= 200 {
+		w.applied = true
+		w.apply(w.Header())
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *headerWriter) Write(p []byte) (int, error) {
+	if !w.applied {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *headerWriter) Flush() {
+	if !w.applied {
+		w.WriteHeader(http.StatusOK)
+	}
+	http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+func (w *headerWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,138 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"sync/atomic"
+)
+
+// ipRanges is a list of IP ranges.
+type ipRanges []netip.Prefix
+
+// parseIPRanges parses the CIDRs or IP addresses of values.
+func parseIPRanges(values []string) (ranges ipRanges, err error) {
+	for _, v := range values {
+		var p netip.Prefix
+		if strings.Contains(v, "/") {
+			if p, err = netip.ParsePrefix(v); err != nil {
+				return nil, fmt.Errorf("bad CIDR %q", v)
+			}
+			p = p.Masked()
+		} else {
+			var addr netip.Addr
+			if addr, err = netip.ParseAddr(v); err != nil {
+				return nil, fmt.Errorf("bad IP address %q", v)
+			}
+			p = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		ranges = append(ranges, p)
+	}
+	return
+}
+
+// Contains reports whether the IP address ip is in ranges.
+func (ranges ipRanges) Contains(ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, p := range ranges {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// trustedProxies is the ranges of proxies trusted to set X-Forwarded-For.
+var trustedProxies atomic.Pointer[ipRanges]
+
+// clientIP returns the IP address of the client of request. If the remote
+// address is a trusted proxy, it is the last untrusted address of
+// X-Forwarded-For header.
+func clientIP(r *http.Request) string {
+	ip := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		ip = host
+	}
+
+	trusted := trustedProxies.Load()
+	if trusted == nil || len(*trusted) == 0 || !trusted.Contains(ip) {
+		return ip
+	}
+
+	forwarded := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		v := strings.TrimSpace(forwarded[i])
+		if v == "" {
+			continue
+		}
+		if _, err := netip.ParseAddr(v); err != nil {
+			break
+		}
+		ip = v
+		if !trusted.Contains(v) {
+			break
+		}
+	}
+	return ip
+}
+
+// accessList is the allowed and denied IP ranges.
+type accessList struct {
+	allow, deny ipRanges
+}
+
+// newAccessList creates the access list of allow and deny. Returns nil if both
+// are empty.
+func newAccessList(allow, deny []string) (l *accessList, err error) {
+	if len(allow) == 0 && len(deny) == 0 {
+		return
+	}
+	l = &accessList{}
+	if l.allow, err = parseIPRanges(allow); err != nil {
+		return nil, fmt.Errorf("allow: %s", err)
+	}
+	if l.deny, err = parseIPRanges(deny); err != nil {
+		return nil, fmt.Errorf("deny: %s", err)
+	}
+	return
+}
+
+// Allowed reports whether ip isn't denied and, if there are allowed ranges,
+// is allowed.
+func (l *accessList) Allowed(ip string) bool {
+	if l == nil {
+		return true
+	}
+	if l.deny.Contains(ip) {
+		return false
+	}
+	return len(l.allow) == 0 || l.allow.Contains(ip)
+}
+
+// logDenied logs the access denied to ip.
+func logDenied(kind, route, ip string) {
+	log.Printf("Access denied to %s: %s %q", ip, kind, route)
+}
+
+// accessListHandler responds 403 to the clients denied by l.
+func accessListHandler(l *accessList, route string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ip := clientIP(r); !l.Allowed(ip) {
+			name := route
+			if name == "" {
+				name = r.Host + r.URL.Path
+			}
+			logDenied("HTTP", name, ip)
+			writeError(w, r, http.StatusForbidden, "Forbidden")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,154 @@
+package server
+
+import (
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+)
+
+// maintenanceInterval is the interval of maintenance flag files checks.
+const maintenanceInterval = 2 * time.Second
+
+// MaintenanceConfig is the maintenance state of route. HTTP requests get 503
+// with Retry-After, and tunnels get an "ERROR:" message.
+type MaintenanceConfig struct {
+	// Enabled if true, the route is in maintenance.
+	Enabled bool `yaml:"enabled"`
+	// File is a flag file: the route is in maintenance while the file
+	// exists. It is checked every 2 seconds, and on SIGUSR2.
+	File string `yaml:"file"`
+	// RetryAfter is the time in seconds of the Retry-After header. If zero,
+	// the header isn't sent.
+	RetryAfter uint32 `yaml:"retry_after"`
+	// Page is the HTML template file of the HTTP 503 response. If blank,
+	// uses the 503 error page.
+	Page string `yaml:"page"`
+	// Message is the .Message of page and the error message of tunnels
+	// (default is "under maintenance").
+	Message string `yaml:"message"`
+	// Allow is the client CIDRs or IP addresses served during maintenance.
+	Allow []string `yaml:"allow"`
+}
+
+// maintenance is the runtime maintenance state of route.
+type maintenance struct {
+	kind, route string
+	enabled     bool
+	file        string
+	active      atomic.Bool
+	retryAfter  time.Duration
+	page        *template.Template
+	message     string
+	allow       ipRanges
+}
+
+// newMaintenance creates the maintenance of route. Returns nil if cfg is nil,
+// or isn't enabled and hasn't a flag file.
+func newMaintenance(kind, route string, cfg *MaintenanceConfig) (m *maintenance, err error) {
+	if cfg == nil || (!cfg.Enabled && cfg.File == "") {
+		return
+	}
+
+	m = &maintenance{
+		kind:       kind,
+		route:      route,
+		enabled:    cfg.Enabled,
+		file:       cfg.File,
+		retryAfter: time.Second * time.Duration(cfg.RetryAfter),
+		message:    cfg.Message,
+	}
+
+	if m.message == "" {
+		m.message = "under maintenance"
+	}
+
+	if m.allow, err = parseIPRanges(cfg.Allow); err != nil {
+		return nil, fmt.Errorf("maintenance allow: %s", err)
+	}
+
+	if cfg.Page != "" {
+		var data []byte
+		if data, err = os.ReadFile(cfg.Page); err != nil {
+			return nil, fmt.Errorf("maintenance page: %s", err)
+		}
+		if m.page, err = template.New(filepath.Base(cfg.Page)).Parse(string(data)); err != nil {
+			return nil, fmt.Errorf("maintenance page: %s", err)
+		}
+	}
+
+	m.active.Store(m.isActive())
+	return
+}
+
+func (m *maintenance) isActive() bool {
+	if m.enabled {
+		return true
+	}
+	_, err := os.Stat(m.file)
+	return err == nil
+}
+
+// Check updates the state of flag file.
+func (m *maintenance) Check() {
+	if active := m.isActive(); m.active.Swap(active) != active {
+		state := "OFF"
+		if active {
+			state = "ON"
+		}
+		log.Printf("%s %q: maintenance %s", m.kind, m.route, state)
+	}
+}
+
+// Active reports whether the route is in maintenance to client ip.
+func (m *maintenance) Active(ip string) bool {
+	return m != nil && m.active.Load() && !m.allow.Contains(ip)
+}
+
+// maintenanceHandler responds 503 while the route is in maintenance.
+func maintenanceHandler(m *maintenance, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !m.Active(clientIP(r)) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if m.retryAfter > 0 {
+			w.Header().Set("Retry-After", retryAfterSeconds(m.retryAfter))
+		}
+		if m.page != nil {
+			renderErrorPage(w, r, http.StatusServiceUnavailable, m.message, m.page)
+		} else {
+			writeError(w, r, http.StatusServiceUnavailable, m.message)
+		}
+	})
+}
+
+// watchMaintenance checks the maintenance flag files of current routes by
+// interval and on maintenanceSignals.
+func (d *dispatcher) watchMaintenance() {
+	var (
+		ch     = make(chan os.Signal, 1)
+		ticker = time.NewTicker(maintenanceInterval)
+	)
+
+	if len(maintenanceSignals) > 0 {
+		signal.Notify(ch, maintenanceSignals...)
+	}
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-ch:
+		}
+
+		for _, m := range d.current.Load().maintenances {
+			m.Check()
+		}
+	}
+}
@@ -0,0 +1,233 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type MetricsConfig struct {
+	// Path is the path of metrics endpoint (default is "/metrics").
+	Path string `yaml:"path"`
+	// Addr if set, serves the metrics on this address instead of the
+	// server address. Otherwise, the metrics path of server is reserved for
+	// the metrics, allowed by the server access lists (allow and deny).
+	Addr     string `yaml:"addr"`
+	Disabled bool   `yaml:"disabled"`
+}
+
+// metrics is the metrics of server, kept between reloads.
+var metrics = struct {
+	httpRequests    *metricVec
+	httpDuration    *histogramVec
+	httpBytes       *metricVec
+	tunnelsActive   *metricVec
+	tunnels         *metricVec
+	tunnelBytes     *metricVec
+	authFailures    *metricVec
+	upstreamErrors  *metricVec
+	upgradeFailures *metricVec
+	rateLimited     *metricVec
+	upstreamRetries *metricVec
+	mirrorRequests  *metricVec
+	mirrorErrors    *metricVec
+	registry        []collector
+}{}
+
+func init() {
+	m := &metrics
+	m.httpRequests = newMetricVec("httpdx_http_requests_total", "counter", "Total of HTTP requests.", "route", "code")
+	m.httpDuration = newHistogramVec("httpdx_http_request_duration_seconds", "HTTP request latencies in seconds.", "route", "code")
+	m.httpBytes = newMetricVec("httpdx_http_response_bytes_total", "counter", "Total of HTTP response body bytes.", "route")
+	m.tunnelsActive = newMetricVec("httpdx_tunnels_active", "gauge", "Number of active tunnels.", "route")
+	m.tunnels = newMetricVec("httpdx_tunnels_total", "counter", "Total of tunnels.", "route")
+	m.tunnelBytes = newMetricVec("httpdx_tunnel_bytes_total", "counter", "Total of tunnel bytes. The direction in is from client to upstream.", "route", "direction")
+	m.authFailures = newMetricVec("httpdx_auth_failures_total", "counter", "Total of authentication failures.", "kind", "route")
+	m.upstreamErrors = newMetricVec("httpdx_upstream_errors_total", "counter", "Total of upstream connection errors.", "kind", "route", "upstream")
+	m.upgradeFailures = newMetricVec("httpdx_websocket_upgrade_failures_total", "counter", "Total of websocket upgrade failures.")
+	m.rateLimited = newMetricVec("httpdx_rate_limited_total", "counter", "Total of HTTP requests and tunnels rejected by rate limits.", "kind", "route")
+	m.upstreamRetries = newMetricVec("httpdx_upstream_retries_total", "counter", "Total of retries of failed upstream connections.", "kind", "route")
+	m.mirrorRequests = newMetricVec("httpdx_mirror_requests_total", "counter", "Total of requests copied to shadow upstreams.", "route", "upstream")
+	m.mirrorErrors = newMetricVec("httpdx_mirror_errors_total", "counter", "Total of failed or dropped shadow requests.", "route", "upstream")
+	m.registry = []collector{
+		m.httpRequests, m.httpDuration, m.httpBytes,
+		m.tunnelsActive, m.tunnels, m.tunnelBytes,
+		m.authFailures, m.upstreamErrors, m.upgradeFailures, m.rateLimited, m.upstreamRetries,
+		m.mirrorRequests, m.mirrorErrors,
+	}
+}
+
+type collector interface {
+	write(w *bufio.Writer)
+}
+
+// metricVec is a counter or gauge partitioned by labels.
+type metricVec struct {
+	name, typ, help string
+	labels          []string
+	mu              sync.Mutex
+	values          map[string]*atomic.Int64
+}
+
+func newMetricVec(name, typ, help string, labels ...string) *metricVec {
+	return &metricVec{name: name, typ: typ, help: help, labels: labels, values: map[string]*atomic.Int64{}}
+}
+
+func (m *metricVec) with(values ...string) *atomic.Int64 {
+	key := strings.Join(values, "\xff")
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	v := m.values[key]
+	if v == nil {
+		v = &atomic.Int64{}
+		m.values[key] = v
+	}
+	return v
+}
+
+// Add adds delta to the value of label values.
+func (m *metricVec) Add(delta int64, values ...string) {
+	m.with(values...).Add(delta)
+}
+
+// Inc increments the value of label values.
+func (m *metricVec) Inc(values ...string) {
+	m.with(values...).Add(1)
+}
+
+func (m *metricVec) write(w *bufio.Writer) {
+	writeHeader(w, m.name, m.typ, m.help)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, key := range sortedKeys(m.values) {
+		fmt.Fprintf(w, "%s%s %d\n", m.name, labelsString(m.labels, key, ""), m.values[key].Load())
+	}
+}
+
+var defaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+type histogram struct {
+	mu     sync.Mutex
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// histogramVec is a histogram partitioned by labels.
+type histogramVec struct {
+	name, help string
+	labels     []string
+	mu         sync.Mutex
+	values     map[string]*histogram
+}
+
+func newHistogramVec(name, help string, labels ...string) *histogramVec {
+	return &histogramVec{name: name, help: help, labels: labels, values: map[string]*histogram{}}
+}
+
+// Observe adds the value v of label values.
+func (m *histogramVec) Observe(v float64, values ...string) {
+	key := strings.Join(values, "\xff")
+
+	m.mu.Lock()
+	h := m.values[key]
+	if h == nil {
+		h = &histogram{counts: make([]uint64, len(defaultBuckets))}
+		m.values[key] = h
+	}
+	m.mu.Unlock()
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for i, b := range defaultBuckets {
+		if v <= b {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += v
+}
+
+func (m *histogramVec) write(w *bufio.Writer) {
+	writeHeader(w, m.name, "histogram", m.help)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, key := range sortedKeys(m.values) {
+		h := m.values[key]
+		h.mu.Lock()
+		for i, b := range defaultBuckets {
+			le := `le="` + strconv.FormatFloat(b, 'g', -1, 64) + `"`
+			fmt.Fprintf(w, "%s_bucket%s %d\n", m.name, labelsString(m.labels, key, le), h.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", m.name, labelsString(m.labels, key, `le="+Inf"`), h.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", m.name, labelsString(m.labels, key, ""), strconv.FormatFloat(h.sum, 'g', -1, 64))
+		fmt.Fprintf(w, "%s_count%s %d\n", m.name, labelsString(m.labels, key, ""), h.count)
+		h.mu.Unlock()
+	}
+}
+
+func writeHeader(w *bufio.Writer, name, typ, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+func sortedKeys[T any](m map[string]T) (keys []string) {
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return
+}
+
+var labelValueReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func labelsString(names []string, key, extra string) string {
+	if len(names) == 0 && extra == "" {
+		return ""
+	}
+
+	var (
+		pairs  []string
+		values = strings.Split(key, "\xff")
+	)
+
+	for i, name := range names {
+		pairs = append(pairs, name+`="`+labelValueReplacer.Replace(values[i])+`"`)
+	}
+	if extra != "" {
+		pairs = append(pairs, extra)
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// metricsHandler serves the metrics in the Prometheus text format.
+func metricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		bw := bufio.NewWriter(w)
+		for _, c := range metrics.registry {
+			c.write(bw)
+		}
+		bw.Flush()
+	})
+}
+
+// observeRequest records the metrics of HTTP request of route.
+func observeRequest(route string, status int, size int64, duration time.Duration) {
+	code := strconv.Itoa(status)
+	metrics.httpRequests.Inc(route, code)
+	metrics.httpDuration.Observe(duration.Seconds(), route, code)
+	metrics.httpBytes.Add(size, route)
+}
@@ -0,0 +1,153 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/http/httputil"
+	"time"
+)
+
+// mirrorMaxInflight is the maximum shadow requests in progress of route. The
+// requests over it aren't mirrored.
+const mirrorMaxInflight = 100
+
+type MirrorConfig struct {
+	// Upstreams is the shadow upstreams addresses, in the forms of Addr.
+	Upstreams []string `yaml:"upstreams"`
+	// Percent is the percent of mirrored requests (default is 100%).
+	Percent uint8 `yaml:"percent"`
+	// BodyLimit is the maximum request body size in bytes mirrored (default
+	// is 1MB). The requests with larger bodies aren't mirrored.
+	BodyLimit int64 `yaml:"body_limit"`
+	// Timeout of shadow requests in seconds (default is 10s).
+	Timeout  uint8 `yaml:"timeout"`
+	Disabled bool  `yaml:"disabled"`
+}
+
+func (c *MirrorConfig) Defaults() {
+	if c.Percent == 0 {
+		c.Percent = 100
+	}
+	if c.BodyLimit == 0 {
+		c.BodyLimit = 1 << 20
+	}
+	if c.Timeout == 0 {
+		c.Timeout = 10
+	}
+}
+
+// discardWriter discards the response, recording the status code.
+type discardWriter struct {
+	header http.Header
+	status int
+}
+
+func (w *discardWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *discardWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+}
+
+func (w *discardWriter) Write(p []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	return len(p), nil
+}
+
+// mirrorHandler copies the requests of route to the shadow upstreams of cfg,
+// asynchronously, before serve next. The shadow responses are discarded.
+func mirrorHandler(name, pth string, cfg *HttpConfig, tlsConfig *tls.Config, next http.Handler) (http.Handler, error) {
+	mc := cfg.Mirror
+	mc.Defaults()
+
+	if len(mc.Upstreams) == 0 {
+		return nil, fmt.Errorf("mirror: upstreams is empty")
+	}
+	if mc.Percent > 100 {
+		return nil, fmt.Errorf("mirror: bad percent %d", mc.Percent)
+	}
+
+	var (
+		shadows  []*upstream
+		timeout  = time.Second * time.Duration(mc.Timeout)
+		inflight = make(chan struct{}, mirrorMaxInflight)
+	)
+
+	for _, addr := range mc.Upstreams {
+		u, err := newUpstream(name, pth, cfg, addr, tlsConfig)
+		if err != nil {
+			return nil, fmt.Errorf("mirror: %s", err)
+		}
+		if rv, ok := u.proxy.(*httputil.ReverseProxy); ok {
+			rv.ErrorHandler = func(w http.ResponseWriter, _ *http.Request, _ error) {
+				w.WriteHeader(http.StatusBadGateway)
+			}
+		}
+		shadows = append(shadows, u)
+	}
+
+	mirror := func(r *http.Request, body []byte, u *upstream) {
+		select {
+		case inflight <- struct{}{}:
+		default:
+			metrics.mirrorErrors.Inc(name, u.addr)
+			return
+		}
+
+		metrics.mirrorRequests.Inc(name, u.addr)
+
+		// the request is cloned here, because the caller keeps using r.
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(r.Context()), timeout)
+		r2 := r.Clone(ctx)
+		r2.Body = io.NopCloser(bytes.NewReader(body))
+		r2.ContentLength = int64(len(body))
+		r2.TransferEncoding = nil
+
+		go func() {
+			defer func() { <-inflight }()
+			defer cancel()
+
+			w := &discardWriter{header: http.Header{}}
+			u.proxy.ServeHTTP(w, r2)
+			if w.status == 0 || w.status >= 500 {
+				metrics.mirrorErrors.Inc(name, u.addr)
+			}
+		}()
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isUpgrade(r) || rand.Intn(100) >= int(mc.Percent) || r.ContentLength > mc.BodyLimit {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		var body []byte
+		if r.Body != nil && r.Body != http.NoBody {
+			var err error
+			body, err = io.ReadAll(io.LimitReader(r.Body, mc.BodyLimit+1))
+			// the next handler reads the whole body
+			r.Body = struct {
+				io.Reader
+				io.Closer
+			}{io.MultiReader(bytes.NewReader(body), r.Body), r.Body}
+			if err != nil || int64(len(body)) > mc.BodyLimit {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+
+		for _, u := range shadows {
+			mirror(r, body, u)
+		}
+
+		next.ServeHTTP(w, r)
+	}), nil
+}
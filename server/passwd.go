@@ -0,0 +1,358 @@
+package server
+
+import (
+	"bufio"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"hash"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	HashBcrypt = "bcrypt"
+	HashSHA256 = "sha256"
+	HashSHA512 = "sha512"
+)
+
+// HashPassword returns the hash of password by algorithm: bcrypt (default),
+// sha256 or sha512 (SHA-crypt).
+func HashPassword(algorithm, password string) (string, error) {
+	switch algorithm {
+	case "", HashBcrypt:
+		h, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+		return string(h), err
+	case HashSHA256:
+		return shaCrypt(sha256.New, "$5$", password, randomSalt(16), shaCryptRounds, false), nil
+	case HashSHA512:
+		return shaCrypt(sha512.New, "$6$", password, randomSalt(16), shaCryptRounds, false), nil
+	default:
+		return "", fmt.Errorf("unknown hash algorithm %q", algorithm)
+	}
+}
+
+// checkPassword reports whether password matches the expected value. The
+// expected value is a bcrypt ($2a$, $2b$, $2y$), SHA-crypt ($5$, $6$),
+// Apache MD5 ($apr1$) or {SHA} hash, otherwise it is a plaintext password.
+func checkPassword(expected, password string) bool {
+	var computed string
+
+	switch {
+	case strings.HasPrefix(expected, "$2a$"),
+		strings.HasPrefix(expected, "$2b$"),
+		strings.HasPrefix(expected, "$2y$"):
+		return bcrypt.CompareHashAndPassword([]byte(expected), []byte(password)) == nil
+	case strings.HasPrefix(expected, "$5$"):
+		computed = shaCryptFrom(sha256.New, "$5$", expected, password)
+	case strings.HasPrefix(expected, "$6$"):
+		computed = shaCryptFrom(sha512.New, "$6$", expected, password)
+	case strings.HasPrefix(expected, "$apr1$"):
+		salt, _, _ := strings.Cut(expected[len("$apr1$"):], "$")
+		computed = apr1Crypt(password, salt)
+	case strings.HasPrefix(expected, "{SHA}"):
+		sum := sha1.Sum([]byte(password))
+		computed = "{SHA}" + base64.StdEncoding.EncodeToString(sum[:])
+	default:
+		expectedHash := sha256.Sum256([]byte(expected))
+		passwordHash := sha256.Sum256([]byte(password))
+		return subtle.ConstantTimeCompare(passwordHash[:], expectedHash[:]) == 1
+	}
+
+	return subtle.ConstantTimeCompare([]byte(computed), []byte(expected)) == 1
+}
+
+const (
+	cryptAlphabet  = "./0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+	shaCryptRounds = 5000
+)
+
+func randomSalt(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	for i := range b {
+		b[i] = cryptAlphabet[int(b[i])%len(cryptAlphabet)]
+	}
+	return string(b)
+}
+
+// cryptEncode appends the n characters of the 24 bits of b2, b1 and b0.
+func cryptEncode(dst []byte, b2, b1, b0 byte, n int) []byte {
+	w := uint(b2)<<16 | uint(b1)<<8 | uint(b0)
+	for ; n > 0; n-- {
+		dst = append(dst, cryptAlphabet[w&0x3f])
+		w >>= 6
+	}
+	return dst
+}
+
+// shaCryptFrom computes the SHA-crypt of password using the salt and rounds
+// of the hash value.
+func shaCryptFrom(newHash func() hash.Hash, magic, value, password string) string {
+	var (
+		settings     = strings.TrimPrefix(value, magic)
+		rounds       = shaCryptRounds
+		roundsCustom bool
+	)
+
+	if strings.HasPrefix(settings, "rounds=") {
+		r, rest, _ := strings.Cut(settings[len("rounds="):], "$")
+		n, err := strconv.Atoi(r)
+		if err != nil {
+			return ""
+		}
+		rounds, roundsCustom, settings = n, true, rest
+	}
+
+	salt, _, _ := strings.Cut(settings, "$")
+	return shaCrypt(newHash, magic, password, salt, rounds, roundsCustom)
+}
+
+// shaCrypt implements the SHA-crypt algorithm of Ulrich Drepper.
+func shaCrypt(newHash func() hash.Hash, magic, password, salt string, rounds int, roundsCustom bool) string {
+	if len(salt) > 16 {
+		salt = salt[:16]
+	}
+	if rounds < 1000 {
+		rounds = 1000
+	} else if rounds > 999999999 {
+		rounds = 999999999
+	}
+
+	var (
+		p    = []byte(password)
+		s    = []byte(salt)
+		h    = newHash()
+		size = h.Size()
+	)
+
+	h.Write(p)
+	h.Write(s)
+	h.Write(p)
+	b := h.Sum(nil)
+
+	h = newHash()
+	h.Write(p)
+	h.Write(s)
+	i := len(p)
+	for ; i > size; i -= size {
+		h.Write(b)
+	}
+	h.Write(b[:i])
+	for i = len(p); i > 0; i >>= 1 {
+		if i&1 != 0 {
+			h.Write(b)
+		} else {
+			h.Write(p)
+		}
+	}
+	a := h.Sum(nil)
+
+	h = newHash()
+	for range p {
+		h.Write(p)
+	}
+	dp := h.Sum(nil)
+	ps := make([]byte, 0, len(p))
+	for i = len(p); i > size; i -= size {
+		ps = append(ps, dp...)
+	}
+	ps = append(ps, dp[:i]...)
+
+	h = newHash()
+	for i = 0; i < 16+int(a[0]); i++ {
+		h.Write(s)
+	}
+	ds := h.Sum(nil)
+	ss := make([]byte, 0, len(s))
+	for i = len(s); i > size; i -= size {
+		ss = append(ss, ds...)
+	}
+	ss = append(ss, ds[:i]...)
+
+	c := a
+	for i = 0; i < rounds; i++ {
+		h = newHash()
+		if i&1 != 0 {
+			h.Write(ps)
+		} else {
+			h.Write(c)
+		}
+		if i%3 != 0 {
+			h.Write(ss)
+		}
+		if i%7 != 0 {
+			h.Write(ps)
+		}
+		if i&1 != 0 {
+			h.Write(c)
+		} else {
+			h.Write(ps)
+		}
+		c = h.Sum(nil)
+	}
+
+	out := []byte(magic)
+	if roundsCustom {
+		out = append(out, "rounds="+strconv.Itoa(rounds)+"$"...)
+	}
+	out = append(out, s...)
+	out = append(out, '$')
+
+	if size == sha256.Size {
+		for i = 0; i < 10; i++ {
+			out = cryptEncode(out, c[i*21%30], c[(i*21+10)%30], c[(i*21+20)%30], 4)
+		}
+		out = cryptEncode(out, 0, c[31], c[30], 3)
+	} else {
+		for i = 0; i < 21; i++ {
+			out = cryptEncode(out, c[i*22%63], c[(i*22+21)%63], c[(i*22+42)%63], 4)
+		}
+		out = cryptEncode(out, 0, 0, c[63], 2)
+	}
+	return string(out)
+}
+
+// apr1Crypt implements the Apache MD5 crypt, the default of htpasswd.
+func apr1Crypt(password, salt string) string {
+	if len(salt) > 8 {
+		salt = salt[:8]
+	}
+
+	var (
+		p = []byte(password)
+		s = []byte(salt)
+		h = md5.New()
+	)
+
+	h.Write(p)
+	h.Write(s)
+	h.Write(p)
+	final := h.Sum(nil)
+
+	h = md5.New()
+	h.Write(p)
+	h.Write([]byte("$apr1$"))
+	h.Write(s)
+	for i := len(p); i > 0; i -= 16 {
+		h.Write(final[:min(i, 16)])
+	}
+	for i := len(p); i > 0; i >>= 1 {
+		if i&1 != 0 {
+			h.Write([]byte{0})
+		} else {
+			h.Write(p[:1])
+		}
+	}
+	final = h.Sum(nil)
+
+	for i := 0; i < 1000; i++ {
+		h = md5.New()
+		if i&1 != 0 {
+			h.Write(p)
+		} else {
+			h.Write(final)
+		}
+		if i%3 != 0 {
+			h.Write(s)
+		}
+		if i%7 != 0 {
+			h.Write(p)
+		}
+		if i&1 != 0 {
+			h.Write(final)
+		} else {
+			h.Write(p)
+		}
+		final = h.Sum(nil)
+	}
+
+	out := []byte("$apr1$" + salt + "$")
+	for _, i := range [][3]int{{0, 6, 12}, {1, 7, 13}, {2, 8, 14}, {3, 9, 15}, {4, 10, 5}} {
+		out = cryptEncode(out, final[i[0]], final[i[1]], final[i[2]], 4)
+	}
+	return string(cryptEncode(out, 0, 0, final[11], 2))
+}
+
+// htpasswd is the users of an htpasswd file, reloaded when the file changes.
+type htpasswd struct {
+	file  string
+	users atomic.Pointer[map[string]string]
+	stop  chan struct{}
+}
+
+func loadHtpasswd(file string) (h *htpasswd, err error) {
+	h = &htpasswd{file: file, stop: make(chan struct{})}
+	if err = h.load(); err != nil {
+		return nil, err
+	}
+
+	go func() {
+		changed := watchFile(file, 2*time.Second, h.stop)
+		for {
+			select {
+			case <-h.stop:
+				return
+			case <-changed:
+				if err := h.load(); err != nil {
+					log.Printf("htpasswd %q reload failed: %s", file, err)
+				} else {
+					log.Printf("htpasswd %q reloaded", file)
+				}
+			}
+		}
+	}()
+	return
+}
+
+func (h *htpasswd) load() error {
+	f, err := os.Open(h.file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var (
+		users   = map[string]string{}
+		scanner = bufio.NewScanner(f)
+	)
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		if user, password, ok := strings.Cut(line, ":"); ok {
+			users[user] = password
+		}
+	}
+
+	if err = scanner.Err(); err != nil {
+		return err
+	}
+
+	h.users.Store(&users)
+	return nil
+}
+
+// Check reports whether the password of user matches.
+func (h *htpasswd) Check(user, password string) bool {
+	expected, ok := (*h.users.Load())[user]
+	return ok && checkPassword(expected, password)
+}
+
+func (h *htpasswd) Close() error {
+	close(h.stop)
+	return nil
+}
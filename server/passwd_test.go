@@ -0,0 +1,102 @@
+package server
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"hash"
+	"testing"
+)
+
+const longPassword = "a very much longer text to encrypt.  This one even stretches over morethan one line."
+
+// shaCryptTests are the reference vectors of the SHA-crypt specification.
+var shaCryptTests = []struct {
+	newHash  func() hash.Hash
+	magic    string
+	settings string
+	password string
+	want     string
+}{
+	{sha256.New, "$5$", "$5$saltstring", "Hello world!",
+		"$5$saltstring$5B8vYYiY.CVt1RlTTf8KbXBH3hsxY/GNooZaBBGWEc5"},
+	{sha256.New, "$5$", "$5$rounds=10000$saltstringsaltstring", "Hello world!",
+		"$5$rounds=10000$saltstringsaltst$3xv.VbSHBb41AL9AvLeujZkZRBAwqFMz2.opqey6IcA"},
+	{sha256.New, "$5$", "$5$rounds=5000$toolongsaltstring", "This is just a test",
+		"$5$rounds=5000$toolongsaltstrin$Un/5jzAHMgOGZ5.mWJpuVolil07guHPvOW8mGRcvxa5"},
+	{sha256.New, "$5$", "$5$rounds=1400$anotherlongsaltstring", longPassword,
+		"$5$rounds=1400$anotherlongsalts$Rx.j8H.h8HjEDGomFU8bDkXm3XIUnzyxf12oP84Bnq1"},
+	{sha256.New, "$5$", "$5$rounds=77777$short", "we have a short salt string but not a short password",
+		"$5$rounds=77777$short$JiO1O3ZpDAxGJeaDIuqCoEFysAe1mZNJRs3pw0KQRd/"},
+	{sha256.New, "$5$", "$5$rounds=123456$asaltof16chars..", "a short string",
+		"$5$rounds=123456$asaltof16chars..$gP3VQ/6X7UUEW3HkBn2w1/Ptq2jxPyzV/cZKmF/wJvD"},
+	{sha256.New, "$5$", "$5$rounds=10$roundstoolow", "the minimum number is still observed",
+		"$5$rounds=1000$roundstoolow$yfvwcWrQ8l/K0DAWyuPMDNHpIVlTQebY9l/gL972bIC"},
+	{sha512.New, "$6$", "$6$saltstring", "Hello world!",
+		"$6$saltstring$svn8UoSVapNtMuq1ukKS4tPQd8iKwSMHWjl/O817G3uBnIFNjnQJuesI68u4OTLiBFdcbYEdFCoEOfaS35inz1"},
+	{sha512.New, "$6$", "$6$rounds=10000$saltstringsaltstring", "Hello world!",
+		"$6$rounds=10000$saltstringsaltst$OW1/O6BYHV6BcXZu8QVeXbDWra3Oeqh0sbHbbMCVNSnCM/UrjmM0Dp8vOuZeHBy/YTBmSK6H9qs/y3RnOaw5v."},
+	{sha512.New, "$6$", "$6$rounds=5000$toolongsaltstring", "This is just a test",
+		"$6$rounds=5000$toolongsaltstrin$lQ8jolhgVRVhY4b5pZKaysCLi0QBxGoNeKQzQ3glMhwllF7oGDZxUhx1yxdYcz/e1JSbq3y6JMxxl8audkUEm0"},
+	{sha512.New, "$6$", "$6$rounds=1400$anotherlongsaltstring", longPassword,
+		"$6$rounds=1400$anotherlongsalts$POfYwTEok97VWcjxIiSOjiykti.o/pQs.wPvMxQ6Fm7I6IoYN3CmLs66x9t0oSwbtEW7o7UmJEiDwGqd8p4ur1"},
+	{sha512.New, "$6$", "$6$rounds=77777$short", "we have a short salt string but not a short password",
+		"$6$rounds=77777$short$WuQyW2YR.hBNpjjRhpYD/ifIw05xdfeEyQoMxIXbkvr0gge1a1x3yRULJ5CCaUeOxFmtlcGZelFl5CxtgfiAc0"},
+	{sha512.New, "$6$", "$6$rounds=123456$asaltof16chars..", "a short string",
+		"$6$rounds=123456$asaltof16chars..$BtCwjqMJGx5hrJhZywWvt0RLE8uZ4oPwcelCjmw2kSYu.Ec6ycULevoBK25fs2xXgMNrCzIMVcgEJAstJeonj1"},
+	{sha512.New, "$6$", "$6$rounds=10$roundstoolow", "the minimum number is still observed",
+		"$6$rounds=1000$roundstoolow$kUMsbe306n21p9R.FRkW3IGn.S9NPN0x50YhH1xhLsPuWGsUSklZt58jaTfF4ZEQpyUNGc0dqbpBYYBaHHrsX."},
+}
+
+func TestShaCrypt(t *testing.T) {
+	for _, tt := range shaCryptTests {
+		if got := shaCryptFrom(tt.newHash, tt.magic, tt.settings, tt.password); got != tt.want {
+			t.Errorf("shaCrypt(%q, %q) = %q, want %q", tt.settings, tt.password, got, tt.want)
+		}
+		if !checkPassword(tt.want, tt.password) {
+			t.Errorf("checkPassword(%q, %q) = false", tt.want, tt.password)
+		}
+		if checkPassword(tt.want, tt.password+"x") {
+			t.Errorf("checkPassword(%q, %q) = true", tt.want, tt.password+"x")
+		}
+	}
+}
+
+// apr1Tests are the vectors of the Apache htpasswd documentation and of
+// "openssl passwd -apr1".
+var apr1Tests = []struct {
+	salt     string
+	password string
+	want     string
+}{
+	{"r31.....", "myPassword", "$apr1$r31.....$HqJZimcKQFAMYayBlzkrA/"},
+	{"x", "", "$apr1$x$tMwYqBfQwi3FYAr0aJc8M/"},
+	{"toolongsaltstring", "Hello world!", "$apr1$toolongs$nso6ozuKeWIzbK43V6ykZ1"},
+	{"toolongsaltstring", longPassword, "$apr1$toolongs$UHS4OaU8Y5IoZiweuR5LD1"},
+	{"saltstringlong", longPassword, "$apr1$saltstri$/cFL8H/t4fs1ftxvC7Fty."},
+}
+
+func TestApr1Crypt(t *testing.T) {
+	for _, tt := range apr1Tests {
+		if got := apr1Crypt(tt.password, tt.salt); got != tt.want {
+			t.Errorf("apr1Crypt(%q, %q) = %q, want %q", tt.password, tt.salt, got, tt.want)
+		}
+		if !checkPassword(tt.want, tt.password) {
+			t.Errorf("checkPassword(%q, %q) = false", tt.want, tt.password)
+		}
+		if checkPassword(tt.want, tt.password+"x") {
+			t.Errorf("checkPassword(%q, %q) = true", tt.want, tt.password+"x")
+		}
+	}
+}
+
+func TestHashPassword(t *testing.T) {
+	for _, algorithm := range []string{HashBcrypt, HashSHA256, HashSHA512} {
+		h, err := HashPassword(algorithm, "secret")
+		if err != nil {
+			t.Fatalf("HashPassword(%q): %s", algorithm, err)
+		}
+		if !checkPassword(h, "secret") {
+			t.Errorf("checkPassword(%q, %q) = false", h, "secret")
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package server
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	RateLimitKeyIP   = "ip"
+	RateLimitKeyUser = "user"
+)
+
+// RateLimitConfig is a token bucket rate limit by client.
+type RateLimitConfig struct {
+	// Rate is the allowed requests per second of HTTP routes, or the allowed
+	// new tunnels per minute of TCP routes.
+	Rate float64 `yaml:"rate"`
+	// Burst is the maximum requests or tunnels at once (default is Rate,
+	// at least 1).
+	Burst int `yaml:"burst"`
+	// Key is the client key: ip (default) or user (the authenticated user,
+	// or ip if not authenticated).
+	Key      string `yaml:"key"`
+	Disabled bool   `yaml:"disabled"`
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimiter is a token bucket by client key.
+type rateLimiter struct {
+	rate   float64 // tokens per second
+	burst  float64
+	byUser bool
+
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+// newRateLimiter creates the limiter of cfg, where the rate is by per. Returns
+// nil if cfg is nil or disabled.
+func newRateLimiter(cfg *RateLimitConfig, per time.Duration) (*rateLimiter, error) {
+	if cfg == nil || cfg.Disabled {
+		return nil, nil
+	}
+	if cfg.Rate <= 0 {
+		return nil, fmt.Errorf("rate limit: rate must be greater than 0")
+	}
+
+	l := &rateLimiter{
+		rate:      cfg.Rate / per.Seconds(),
+		burst:     float64(cfg.Burst),
+		buckets:   map[string]*tokenBucket{},
+		lastSweep: time.Now(),
+	}
+
+	if l.burst <= 0 {
+		l.burst = math.Max(1, math.Ceil(cfg.Rate))
+	}
+
+	switch cfg.Key {
+	case "", RateLimitKeyIP:
+	case RateLimitKeyUser:
+		l.byUser = true
+	default:
+		return nil, fmt.Errorf("rate limit: bad key %q", cfg.Key)
+	}
+	return l, nil
+}
+
+// key returns the client key of ip and user.
+func (l *rateLimiter) key(ip, user string) string {
+	if l.byUser && user != "" {
+		return "user:" + user
+	}
+	return "ip:" + ip
+}
+
+// Allow takes a token of the client key. If not allowed, returns the time to
+// wait for the next token.
+func (l *rateLimiter) Allow(key string) (ok bool, retryAfter time.Duration) {
+	now := time.Now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.sweep(now)
+
+	b := l.buckets[key]
+	if b == nil {
+		b = &tokenBucket{tokens: l.burst}
+		l.buckets[key] = b
+	} else {
+		b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	}
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+}
+
+// sweep removes the full buckets, at most once per minute.
+func (l *rateLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < time.Minute {
+		return
+	}
+	l.lastSweep = now
+
+	full := time.Duration(l.burst / l.rate * float64(time.Second))
+	for key, b := range l.buckets {
+		if now.Sub(b.last) >= full {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// allowAll takes a token of each limiter. If not allowed, returns the
+// longest time to wait.
+func allowAll(limiters []*rateLimiter, ip, user string) (ok bool, retryAfter time.Duration) {
+	ok = true
+	for _, l := range limiters {
+		if allowed, d := l.Allow(l.key(ip, user)); !allowed {
+			ok = false
+			retryAfter = max(retryAfter, d)
+		}
+	}
+	return
+}
+
+// retryAfterSeconds returns the value of Retry-After header of d.
+func retryAfterSeconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
+
+// rateLimitHandler responds 429 to the clients that exceed the limiters.
+func rateLimitHandler(limiters []*rateLimiter, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ok, retryAfter := allowAll(limiters, clientIP(r), requestUser(r)); !ok {
+			if info := getRequestInfo(r); info != nil {
+				metrics.rateLimited.Inc("http", info.Route)
+			}
+			w.Header().Set("Retry-After", retryAfterSeconds(retryAfter))
+			writeError(w, r, http.StatusTooManyRequests, "Too Many Requests")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// splitRateLimiters splits limiters keyed by ip and keyed by user.
+func splitRateLimiters(limiters ...*rateLimiter) (byIP, byUser []*rateLimiter) {
+	for _, l := range limiters {
+		switch {
+		case l == nil:
+		case l.byUser:
+			byUser = append(byUser, l)
+		default:
+			byIP = append(byIP, l)
+		}
+	}
+	return
+}
@@ -0,0 +1,162 @@
+package server
+
+import (
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+type ReloadConfig struct {
+	// Watch if true, reloads the configuration when the config file changes.
+	Watch bool `yaml:"watch"`
+	// Interval of config file checks in seconds (default is 2s).
+	Interval uint8 `yaml:"interval"`
+}
+
+// Loader loads the server configuration.
+type Loader struct {
+	// File is the config file path.
+	File string
+	Load func() (*Config, error)
+}
+
+// dispatcher serves the current routes, swapped on reload.
+type dispatcher struct {
+	proxy   *Handler
+	current atomic.Pointer[routes]
+	mu      sync.Mutex
+	// active is the number of HTTP requests in progress.
+	active atomic.Int64
+}
+
+func (d *dispatcher) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	d.active.Add(1)
+	defer d.active.Add(-1)
+	d.current.Load().handler.ServeHTTP(w, r)
+}
+
+func (d *dispatcher) set(rts *routes) {
+	d.proxy.SetRoutes(rts.tcp)
+	d.proxy.SetAccessLog(rts.accessLog)
+	currentAccessLog.Store(rts.accessLog)
+	d.proxy.SetAccessList(rts.acl)
+	trustedProxies.Store(&rts.trustedProxies)
+	if old := d.current.Swap(rts); old != nil {
+		old.Close()
+	}
+}
+
+// serverACLHandler serves next to the clients allowed by the server access
+// list of current routes.
+func (d *dispatcher) serverACLHandler(route string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		accessListHandler(d.current.Load().acl, route, next).ServeHTTP(w, r)
+	})
+}
+
+// reload loads and applies the configuration. If the configuration is
+// invalid, the current routes are kept. The established tunnels aren't
+// affected.
+func (d *dispatcher) reload(loader *Loader, reason string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	cfg, err := loader.Load()
+	if err == nil {
+		cfg.TCPSockets.Defaults()
+		var rts *routes
+		if rts, err = buildRoutes(cfg); err == nil {
+			d.set(rts)
+			log.Printf("Configuration reloaded (%s), with targets:\n  %s", reason, strings.Join(rts.targets, "\n  "))
+			return
+		}
+	}
+
+	log.Printf("Configuration reload (%s) refused: %s", reason, err)
+}
+
+func (d *dispatcher) watch(loader *Loader, cfg *ReloadConfig) {
+	var (
+		hup     = make(chan os.Signal, 1)
+		changed <-chan struct{}
+	)
+
+	signal.Notify(hup, syscall.SIGHUP)
+
+	if cfg.Watch && loader.File != "" {
+		interval := cfg.Interval
+		if interval == 0 {
+			interval = 2
+		}
+		changed = watchFile(loader.File, time.Second*time.Duration(interval), nil)
+	}
+
+	for {
+		select {
+		case <-hup:
+			d.reload(loader, "SIGHUP")
+		case <-changed:
+			d.reload(loader, "file changed")
+		}
+	}
+}
+
+// reopenLogs reopens the access log file on reopenSignals.
+func (d *dispatcher) reopenLogs() {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, reopenSignals...)
+
+	for range ch {
+		if l := d.current.Load().accessLog; l != nil {
+			if err := l.Reopen(); err != nil {
+				log.Print(err)
+			} else {
+				log.Printf("Access log reopened")
+			}
+		}
+	}
+}
+
+// watchFile polls the file modification time and size by interval, and
+// notifies the returned channel when it changes, until stop is closed.
+func watchFile(file string, interval time.Duration, stop <-chan struct{}) <-chan struct{} {
+	var (
+		changed = make(chan struct{}, 1)
+		stat    = func() (modTime time.Time, size int64) {
+			if info, err := os.Stat(file); err == nil {
+				return info.ModTime(), info.Size()
+			}
+			return
+		}
+		modTime, size = stat()
+	)
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+
+			if t, s := stat(); !t.Equal(modTime) || s != size {
+				modTime, size = t, s
+				select {
+				case changed <- struct{}{}:
+				default:
+				}
+			}
+		}
+	}()
+
+	return changed
+}
@@ -0,0 +1,112 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"time"
+)
+
+type contextKey string
+
+const infoKey contextKey = "info"
+
+// requestInfo is the information of request handled by a route.
+type requestInfo struct {
+	ID    string
+	Route string
+	User  string
+	Start time.Time
+}
+
+// withRequestInfo returns the request with the info of route, and the info.
+// The request ID is the X-Request-Id header, or a new ID set in the header.
+func withRequestInfo(r *http.Request, route string) (*http.Request, *requestInfo) {
+	info := &requestInfo{ID: r.Header.Get("X-Request-Id"), Route: route, Start: time.Now()}
+	if info.ID == "" {
+		info.ID = newRequestID()
+		r.Header.Set("X-Request-Id", info.ID)
+	}
+	return r.WithContext(context.WithValue(r.Context(), infoKey, info)), info
+}
+
+func newRequestID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// getRequestInfo returns the info of request, or nil if it isn't handled by
+// a route.
+func getRequestInfo(r *http.Request) *requestInfo {
+	info, _ := r.Context().Value(infoKey).(*requestInfo)
+	return info
+}
+
+// requestVars is the variables of request used by templates.
+type requestVars struct {
+	ClientIP  string
+	Host      string
+	Route     string
+	User      string
+	Method    string
+	Path      string
+	Query     string
+	URI       string
+	Scheme    string
+	RequestID string
+}
+
+func newRequestVars(r *http.Request) *requestVars {
+	v := &requestVars{
+		ClientIP: clientIP(r),
+		Host:     r.Host,
+		Method:   r.Method,
+		Path:     r.URL.Path,
+		Query:    r.URL.RawQuery,
+		URI:      r.URL.RequestURI(),
+		Scheme:   "http",
+	}
+	if r.TLS != nil {
+		v.Scheme = "https"
+	}
+	if info := getRequestInfo(r); info != nil {
+		v.Route = info.Route
+		v.User = info.User
+		v.RequestID = info.ID
+	}
+	return v
+}
+
+// requestUser returns the authenticated user of request.
+func requestUser(r *http.Request) string {
+	if info := getRequestInfo(r); info != nil {
+		return info.User
+	}
+	return ""
+}
+
+// routeHandler serves the route name by next, records the metrics and logs
+// the request if the access log is enabled. The request is logged by the
+// current access log when it finishes, so the requests in progress on
+// reload aren't lost.
+func (rts *routes) routeHandler(name string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r, info := withRequestInfo(r, name)
+		sw := &statusWriter{ResponseWriter: w}
+		w.Header().Set("X-Request-Id", info.ID)
+
+		defer func() {
+			if sw.status == 0 {
+				sw.status = http.StatusOK
+			}
+			observeRequest(name, sw.status, sw.size, time.Since(info.Start))
+			if l := currentAccessLog.Load(); l != nil {
+				l.logRequest(r, info, sw.status, sw.size)
+			}
+		}()
+
+		next.ServeHTTP(sw, r)
+	})
+}
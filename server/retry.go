@@ -0,0 +1,210 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// retryBodyLimit is the maximum request body size buffered to retry.
+const retryBodyLimit = 1 << 20
+
+type RetryConfig struct {
+	// Attempts is the maximum retries of a request (default is 2).
+	Attempts uint8 `yaml:"attempts"`
+	// Backoff is the wait in milliseconds before the first retry, doubled on
+	// each next retry (default is 100ms).
+	Backoff uint16 `yaml:"backoff"`
+	// Budget is the maximum retries in percent of the route requests
+	// (default is 20%). Up to 10 retries are allowed upfront.
+	Budget uint8 `yaml:"budget"`
+	// Methods is the retried methods (default is the idempotent methods:
+	// GET, HEAD, OPTIONS, TRACE, PUT and DELETE).
+	Methods  []string `yaml:"methods"`
+	Disabled bool     `yaml:"disabled"`
+}
+
+func (c *RetryConfig) Defaults() {
+	if c.Attempts == 0 {
+		c.Attempts = 2
+	}
+	if c.Backoff == 0 {
+		c.Backoff = 100
+	}
+	if c.Budget == 0 {
+		c.Budget = 20
+	}
+	if len(c.Methods) == 0 {
+		c.Methods = []string{"GET", "HEAD", "OPTIONS", "TRACE", "PUT", "DELETE"}
+	}
+}
+
+type retryPolicy struct {
+	attempts int
+	backoff  time.Duration
+	methods  map[string]bool
+	budget   *retryBudget
+}
+
+// newRetryPolicy creates the policy of cfg. Returns nil if cfg is nil or
+// disabled.
+func newRetryPolicy(cfg *RetryConfig) *retryPolicy {
+	if cfg == nil || cfg.Disabled {
+		return nil
+	}
+
+	cfg.Defaults()
+
+	p := &retryPolicy{
+		attempts: int(cfg.Attempts),
+		backoff:  time.Millisecond * time.Duration(cfg.Backoff),
+		methods:  map[string]bool{},
+		budget:   &retryBudget{ratio: float64(cfg.Budget) / 100, tokens: retryBudgetMax},
+	}
+	for _, m := range cfg.Methods {
+		p.methods[strings.ToUpper(m)] = true
+	}
+	return p
+}
+
+// retryable reports whether r can be retried.
+func (p *retryPolicy) retryable(r *http.Request) bool {
+	return p != nil && p.methods[r.Method] && r.ContentLength >= 0 && r.ContentLength <= retryBodyLimit
+}
+
+const retryBudgetMax = 10
+
+// retryBudget limits the retries to a ratio of requests.
+type retryBudget struct {
+	mu     sync.Mutex
+	ratio  float64
+	tokens float64
+}
+
+// Deposit deposits the ratio of a request.
+func (b *retryBudget) Deposit() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.tokens = min(retryBudgetMax, b.tokens+b.ratio)
+}
+
+// Withdraw takes a retry, if available.
+func (b *retryBudget) Withdraw() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+const attemptKey contextKey = "attempt"
+
+// attempt is the state of an upstream attempt of request. If set in the
+// request context, the proxy error handler records the error in it instead
+// of responding.
+type attempt struct {
+	err error
+}
+
+// proxyError logs and responds the upstream error err: 504 on timeouts,
+// otherwise 502. The error isn't sent to the client.
+func proxyError(w http.ResponseWriter, r *http.Request, err error) {
+	if a, _ := r.Context().Value(attemptKey).(*attempt); a != nil {
+		a.err = err
+		return
+	}
+	if err.Error() == "EOF" {
+		return
+	}
+
+	if bodyErr := bodyError(r); bodyErr != nil {
+		code := bodyErrorStatus(bodyErr)
+		writeError(w, r, code, http.StatusText(code))
+		return
+	}
+
+	route := r.Host + r.URL.Path
+	if info := getRequestInfo(r); info != nil {
+		route = info.Route
+	}
+	log.Printf("HTTP %q: upstream error: %s", route, err)
+
+	code := http.StatusBadGateway
+	if ne, ok := err.(net.Error); (ok && ne.Timeout()) || errors.Is(err, context.DeadlineExceeded) {
+		code = http.StatusGatewayTimeout
+	}
+	writeError(w, r, code, http.StatusText(code))
+}
+
+// serveWithRetries serves r by the upstreams of pool, retrying the failed
+// connections on the next healthy upstream, if any.
+func (p *upstreamPool) serveWithRetries(w http.ResponseWriter, r *http.Request) {
+	var (
+		body  []byte
+		tried []*upstream
+		a     = &attempt{}
+		orig  = r
+	)
+
+	if r.ContentLength > 0 {
+		var err error
+		if body, err = io.ReadAll(io.LimitReader(r.Body, r.ContentLength)); err != nil {
+			code := bodyErrorStatus(err)
+			writeError(w, r, code, http.StatusText(code))
+			return
+		}
+	}
+
+	p.retry.budget.Deposit()
+	r = r.WithContext(context.WithValue(r.Context(), attemptKey, a))
+
+	for i := 0; ; i++ {
+		u := p.pick(r, tried)
+		if u == nil {
+			// all healthy upstreams was tried
+			u = p.pick(r, nil)
+		}
+		if u == nil {
+			writeError(w, r, http.StatusServiceUnavailable, "no healthy upstream")
+			return
+		}
+
+		if body != nil {
+			r.Body = io.NopCloser(bytes.NewReader(body))
+		}
+
+		p.sticky.pin(w, r, u)
+		a.err = nil
+		u.active.Add(1)
+		u.proxy.ServeHTTP(w, r)
+		u.active.Add(-1)
+
+		if a.err == nil {
+			return
+		}
+
+		tried = append(tried, u)
+
+		if i >= p.retry.attempts || !p.retry.budget.Withdraw() {
+			proxyError(w, orig, a.err)
+			return
+		}
+
+		metrics.upstreamRetries.Inc("http", p.name)
+
+		select {
+		case <-time.After(p.retry.backoff << i):
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
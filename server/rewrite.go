@@ -0,0 +1,134 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+type RewriteConfig struct {
+	// Match is the regular expression matched against the request path.
+	Match string `yaml:"match"`
+	// Replace is the new path. Accepts references to capture groups of Match
+	// like $1 or ${name}. If contains "?", the rest is the query string, and
+	// the query of request is appended to it.
+	Replace string `yaml:"replace"`
+	// Last if true, stops the next rules when this rule matches.
+	Last bool `yaml:"last"`
+}
+
+type RedirectConfig struct {
+	// Match is an optional regular expression matched against the request
+	// path. If the path doesn't match, responds not found.
+	Match string `yaml:"match"`
+	// To is the redirect target. It is a go template with the variables:
+	// .ClientIP, .Host, .Route, .User, .Method, .Path, .Query, .URI, .Scheme,
+	// .RequestID and .Groups (the capture groups of Match).
+	To string `yaml:"to"`
+	// Code is the redirect status code: 301, 302 (default), 303, 307 or 308.
+	Code int `yaml:"code"`
+}
+
+type rewriteRule struct {
+	re      *regexp.Regexp
+	replace string
+	last    bool
+}
+
+// rewriteHandler rewrites the request path by rules before serve next.
+func rewriteHandler(rules []*RewriteConfig, next http.Handler) (http.Handler, error) {
+	var compiled []rewriteRule
+
+	for i, rule := range rules {
+		re, err := regexp.Compile(rule.Match)
+		if err != nil {
+			return nil, fmt.Errorf("rewrite #%d: %s", i, err)
+		}
+		compiled = append(compiled, rewriteRule{re, rule.Replace, rule.Last})
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var (
+			pth   = r.URL.Path
+			query = r.URL.RawQuery
+		)
+
+		for _, rule := range compiled {
+			if !rule.re.MatchString(pth) {
+				continue
+			}
+
+			pth = rule.re.ReplaceAllString(pth, rule.replace)
+			if p, q, ok := strings.Cut(pth, "?"); ok {
+				pth = p
+				if query != "" {
+					q += "&" + query
+				}
+				query = q
+			}
+
+			if rule.last {
+				break
+			}
+		}
+
+		if pth != r.URL.Path || query != r.URL.RawQuery {
+			u := *r.URL
+			u.Path, u.RawPath, u.RawQuery = pth, "", query
+			r2 := *r
+			r2.URL = &u
+			r = &r2
+		}
+
+		next.ServeHTTP(w, r)
+	}), nil
+}
+
+// redirectHandler responds the redirect of cfg.
+func redirectHandler(cfg *RedirectConfig) (_ http.Handler, err error) {
+	var (
+		re   *regexp.Regexp
+		to   *valueTemplate
+		code = cfg.Code
+	)
+
+	switch code {
+	case 0:
+		code = http.StatusFound
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther,
+		http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+	default:
+		return nil, fmt.Errorf("bad redirect code %d", code)
+	}
+
+	if cfg.To == "" {
+		return nil, fmt.Errorf("redirect target is blank")
+	}
+
+	if cfg.Match != "" {
+		if re, err = regexp.Compile(cfg.Match); err != nil {
+			return nil, fmt.Errorf("redirect match: %s", err)
+		}
+	}
+
+	if to, err = newValueTemplate("redirect", cfg.To); err != nil {
+		return nil, fmt.Errorf("redirect target: %s", err)
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		vars := struct {
+			*requestVars
+			Groups []string
+		}{requestVars: newRequestVars(r)}
+
+		if re != nil {
+			if vars.Groups = re.FindStringSubmatch(r.URL.Path); vars.Groups == nil {
+				writeError(w, r, http.StatusNotFound, "404 page not found")
+				return
+			}
+		}
+
+		http.Redirect(w, r, to.Execute(vars), code)
+	}), nil
+}
@@ -1,157 +1,610 @@
 package server
 
 import (
+	"context"
+	"crypto/tls"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"net/http/httputil"
-	"net/url"
 	"os"
+	"os/signal"
 	"path"
-	"reflect"
 	"sort"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/moisespsena-go/httpdx/internal"
 )
 
-func Serve(cfg *Config) (err error) {
+// Serve starts the server. If loader isn't nil, the configuration is
+// reloaded on SIGHUP or, if enabled, when the config file changes.
+func Serve(cfg *Config, loader *Loader) (err error) {
 	cfg.TCPSockets.Defaults()
 
 	var (
 		proxyHandler = New(
-			cfg.TCPSockets.Routes,
+			nil,
 			time.Second*time.Duration(cfg.TCPSockets.HandshakeTimeout),
 			time.Second*time.Duration(cfg.TCPSockets.DialTimeout),
 			time.Second*time.Duration(cfg.TCPSockets.WriteTimeout),
 			cfg.TCPSockets.CompressionEnabled,
 		)
-		proxies     []string
-		rootHandler http.Handler
+		mux = http.NewServeMux()
+		d   = &dispatcher{proxy: proxyHandler}
+		rts *routes
 	)
 
-	http.Handle(internal.ProxyPath, http.HandlerFunc(proxyHandler.Proxy()))
+	if rts, err = buildRoutes(cfg); err != nil {
+		return
+	}
 
-	for pth, cfg := range cfg.HTTP.Routes {
-		if cfg.Disabled {
-			continue
+	d.set(rts)
+
+	if len(rts.targets) > 0 {
+		log.Printf("Starting reverse proxy server on %s, with targets:\n  %s", cfg.Addr, strings.Join(rts.targets, "\n  "))
+	} else {
+		log.Printf("Starting reverse proxy server on port %s without targets", cfg.Addr)
+	}
+
+	mux.Handle(internal.ProxyPath, http.HandlerFunc(proxyHandler.Proxy()))
+	mux.Handle("/", d)
+
+	var metricsSrv *http.Server
+
+	if cfg.Metrics != nil && !cfg.Metrics.Disabled {
+		pth := cfg.Metrics.Path
+		if pth == "" {
+			pth = "/metrics"
 		}
 
-		var proxy http.Handler
+		if cfg.Metrics.Addr != "" {
+			metricsMux := http.NewServeMux()
+			metricsMux.Handle(pth, metricsHandler())
+			metricsSrv = &http.Server{Addr: cfg.Metrics.Addr, Handler: metricsMux}
+			go func() {
+				log.Printf("Starting metrics server on %s%s", cfg.Metrics.Addr, pth)
+				if err := metricsSrv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+					log.Printf("Metrics server failed: %s", err)
+				}
+			}()
+		} else {
+			mux.Handle(pth, rts.routeHandler(pth, d.serverACLHandler(pth, metricsHandler())))
+		}
+	}
 
-		if cfg.PathStrip {
-			pth = strings.TrimRight(pth, "/") + "/"
+	if loader != nil {
+		go d.watch(loader, &cfg.Reload)
+	}
+
+	if len(reopenSignals) > 0 {
+		go d.reopenLogs()
+	}
+
+	go d.watchMaintenance()
+
+	var (
+		srv      = &http.Server{Addr: cfg.Addr, Handler: mux}
+		redirect *http.Server
+		listen   = srv.ListenAndServe
+	)
+
+	cfg.HTTP.Timeouts.setup(srv)
+
+	if cfg.TLS != nil && !cfg.TLS.Disabled {
+		if srv.TLSConfig, err = cfg.TLS.Build(); err != nil {
+			return fmt.Errorf("tls: %s", err)
+		}
+
+		if cfg.TLS.RedirectAddr != "" {
+			redirect = &http.Server{Addr: cfg.TLS.RedirectAddr, Handler: redirectToHTTPS(cfg.Addr)}
+			go serveRedirect(redirect)
 		}
 
-		proxy, err = createReverseProxy(pth, cfg)
+		listen = func() error {
+			return srv.ListenAndServeTLS("", "")
+		}
+	}
+
+	var (
+		errCh = make(chan error, 1)
+		sig   = make(chan os.Signal, 2)
+	)
+
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sig)
+
+	go func() {
+		errCh <- listen()
+	}()
+
+	select {
+	case err = <-errCh:
+		return
+	case s := <-sig:
+		log.Printf("Received %s, shutting down", s)
+	}
+
+	drainTimeout := time.Second * time.Duration(cfg.DrainTimeout)
+	if drainTimeout == 0 {
+		drainTimeout = 30 * time.Second
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), drainTimeout)
+	defer cancel()
+
+	go func() {
+		// a second signal forces the close.
+		select {
+		case s := <-sig:
+			log.Printf("Received %s, forcing shutdown", s)
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	var (
+		tunnelsCh = make(chan int, 1)
+		requests  int64
+	)
+
+	go func() {
+		tunnelsCh <- proxyHandler.Shutdown(ctx)
+	}()
+
+	if redirect != nil {
+		redirect.Shutdown(ctx)
+	}
+
+	if metricsSrv != nil {
+		metricsSrv.Shutdown(ctx)
+	}
+
+	if err = srv.Shutdown(ctx); err != nil {
+		requests = d.active.Load()
+		srv.Close()
+	}
+
+	d.current.Load().Close()
+
+	if tunnels := <-tunnelsCh; requests > 0 || tunnels > 0 {
+		log.Printf("Server stopped: %d HTTP requests and %d tunnels were cut", requests, tunnels)
+	} else {
+		log.Printf("Server stopped")
+	}
+	return nil
+}
+
+// routes is the HTTP and TCP routes built from the configuration.
+type routes struct {
+	cfg       *Config
+	handler   http.Handler
+	tcp       map[string]*TCPSocketConfig
+	targets   []string
+	closers   []io.Closer
+	accessLog *accessLog
+	// httpLimiter and tunnelLimiter is the rate limiters of all routes.
+	httpLimiter, tunnelLimiter *rateLimiter
+	// acl is the access list of all HTTP requests and tunnels.
+	acl            *accessList
+	trustedProxies ipRanges
+	errorPages     errorPages
+	maintenances   []*maintenance
+}
+
+// Close releases the resources of routes, like the health checkers.
+func (rts *routes) Close() {
+	for _, c := range rts.closers {
+		c.Close()
+	}
+}
+
+// buildRoutes builds the routes of cfg. Returns error if cfg is invalid.
+func buildRoutes(cfg *Config) (rts *routes, err error) {
+	var (
+		router       = newHostRouter()
+		hostHandlers = map[string]http.Handler{}
+		fallback     http.Handler
+	)
+
+	rts = &routes{
+		cfg:     cfg,
+		handler: router,
+		tcp:     map[string]*TCPSocketConfig{},
+	}
+
+	defer func() {
 		if err != nil {
-			return fmt.Errorf("create reverse proxy failed: %s", err)
+			rts.Close()
+			rts = nil
 		}
+	}()
 
-		if pth == "/" {
-			rootHandler = proxy
-		} else {
-			http.Handle(pth, proxy)
+	if cfg.AccessLog != nil && !cfg.AccessLog.Disabled {
+		if rts.accessLog, err = newAccessLog(cfg.AccessLog); err != nil {
+			return
 		}
+		rts.closers = append(rts.closers, rts.accessLog)
+	}
+
+	if rts.acl, err = newAccessList(cfg.Allow, cfg.Deny); err != nil {
+		return rts, err
+	}
+	if rts.trustedProxies, err = parseIPRanges(cfg.TrustedProxies); err != nil {
+		return rts, fmt.Errorf("trusted proxies: %s", err)
+	}
+	if rts.acl != nil {
+		rts.handler = accessListHandler(rts.acl, "", router)
+	}
 
-		proxies = append(proxies, fmt.Sprintf("HTTP %q 🡒 %s", pth, cfg.ToString(pth)))
+	if rts.httpLimiter, err = newRateLimiter(cfg.HTTP.RateLimit, time.Second); err != nil {
+		return rts, fmt.Errorf("http: %s", err)
+	}
+	if rts.tunnelLimiter, err = newRateLimiter(cfg.TCPSockets.RateLimit, time.Minute); err != nil {
+		return rts, fmt.Errorf("tcp sockets: %s", err)
+	}
+
+	if rts.errorPages, err = loadErrorPages(cfg.ErrorPages, nil); err != nil {
+		return
+	}
+	if len(rts.errorPages) > 0 {
+		rts.handler = errorPagesHandler(rts.errorPages, rts.handler)
+	}
+
+	if !cfg.NotFoundDisabled {
+		notFound := func(w http.ResponseWriter, r *http.Request) {
+			writeErrorPage(w, r, http.StatusNotFound, "404 page not found", fallbackTemplate)
+		}
+
+		if cfg.NotFound != "" {
+			notFound = func(w http.ResponseWriter, r *http.Request) {
+				http.ServeFile(w, r, cfg.NotFound)
+			}
+		}
+
+		fallback = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("X-Httpdx-Handle-Fallback") != "false" {
+				notFound(w, r)
+			}
+		})
+	}
+
+	if router.def, err = rts.createRoutesHandler("", cfg.HTTP.Routes, fallback); err != nil {
+		return
+	}
+
+	for host, hostCfg := range cfg.HTTP.Hosts {
+		if hostCfg.Disabled {
+			continue
+		}
+
+		var handler http.Handler
+
+		if handler, err = rts.createRoutesHandler(host, hostCfg.Routes, fallback); err != nil {
+			return
+		}
+
+		router.Add(host, handler)
+		hostHandlers[host] = handler
+	}
+
+	if cfg.HTTP.DefaultHost != "" {
+		if router.def = hostHandlers[cfg.HTTP.DefaultHost]; router.def == nil {
+			return rts, fmt.Errorf("default host %q is not registered", cfg.HTTP.DefaultHost)
+		}
+		rts.targets = append(rts.targets, fmt.Sprintf("HTTP default host 🡒 %s", cfg.HTTP.DefaultHost))
 	}
 
 	for pth, sck := range cfg.TCPSockets.Routes {
 		if sck.Disabled {
 			continue
 		}
+		if sck.Addr == "" {
+			return rts, fmt.Errorf("tcp socket %q: addr is blank", pth)
+		}
 		if sck.Auth == nil {
 			sck.Auth = cfg.TCPSockets.Auth
 		}
+		if err = rts.setupAuth(sck.Auth); err != nil {
+			return rts, fmt.Errorf("tcp socket %q: %s", pth, err)
+		}
+
+		if sck.acl, err = newAccessList(sck.Allow, sck.Deny); err != nil {
+			return rts, fmt.Errorf("tcp socket %q: %s", pth, err)
+		}
 
-		proxies = append(proxies, fmt.Sprintf("TCP %q 🡒 %s", pth, sck))
+		if sck.maintenance, err = newMaintenance("TCP", pth, sck.Maintenance); err != nil {
+			return rts, fmt.Errorf("tcp socket %q: %s", pth, err)
+		}
+		if sck.maintenance != nil {
+			rts.maintenances = append(rts.maintenances, sck.maintenance)
+		}
+
+		var limiter *rateLimiter
+		if limiter, err = newRateLimiter(sck.RateLimit, time.Minute); err != nil {
+			return rts, fmt.Errorf("tcp socket %q: %s", pth, err)
+		}
+		sck.limiters = nil
+		for _, l := range []*rateLimiter{limiter, rts.tunnelLimiter} {
+			if l != nil {
+				sck.limiters = append(sck.limiters, l)
+			}
+		}
+
+		rts.tcp[pth] = sck
+		rts.targets = append(rts.targets, fmt.Sprintf("TCP %q 🡒 %s", pth, sck))
 	}
 
-	sort.Strings(proxies)
+	sort.Strings(rts.targets)
+	return
+}
 
-	if len(proxies) > 0 {
-		log.Printf("Starting reverse proxy server on %s, with targets:\n  %s", cfg.Addr, strings.Join(proxies, "\n  "))
-	} else {
-		log.Printf("Starting reverse proxy server on port %s without targets", cfg.Addr)
+// createRoutesHandler creates the handler of routes of host. If host is
+// blank, it is the default host.
+func (rts *routes) createRoutesHandler(host string, routes map[string]*HttpConfig, fallback http.Handler) (_ http.Handler, err error) {
+	var (
+		mux         = http.NewServeMux()
+		rootHandler http.Handler
+		prefix      = "HTTP "
+		paths       = map[string]bool{}
+	)
+
+	if host != "" {
+		prefix += host + " "
 	}
 
-	if !cfg.NotFoundDisabled {
-		fallback := func(w http.ResponseWriter, r *http.Request) {
-			w.Header().Set("Content-Type", "text/html")
-			w.Header().Set("X-Content-Type-Options", "nosniff")
-			w.WriteHeader(http.StatusNotFound)
-			fmt.Fprintf(w, fallbackPage, r.URL.Path)
+	for pth, cfg := range routes {
+		if cfg.Disabled {
+			continue
 		}
 
-		if cfg.NotFound != "" {
-			fallback = func(w http.ResponseWriter, r *http.Request) {
-				http.ServeFile(w, r, cfg.NotFound)
+		var proxy http.Handler
+
+		if cfg.PathStrip {
+			pth = strings.TrimRight(pth, "/") + "/"
+		}
+
+		if paths[pth] {
+			return nil, fmt.Errorf("duplicate route %q", host+pth)
+		}
+		paths[pth] = true
+
+		cfg.Timeouts = cfg.Timeouts.Over(rts.cfg.HTTP.Timeouts)
+		if cfg.MaxBodySize == 0 {
+			cfg.MaxBodySize = rts.cfg.HTTP.MaxBodySize
+		}
+
+		if proxy, err = createReverseProxy(host+pth, pth, cfg); err != nil {
+			return nil, fmt.Errorf("create reverse proxy of %q failed: %s", host+pth, err)
+		}
+
+		if c, ok := proxy.(io.Closer); ok {
+			rts.closers = append(rts.closers, c)
+		}
+
+		if len(cfg.Fallback) > 0 {
+			handlers := Handlers{proxy}
+			for i, fbCfg := range cfg.Fallback {
+				var fb http.Handler
+				fbCfg.Timeouts = fbCfg.Timeouts.Over(cfg.Timeouts)
+				if fb, err = createReverseProxy(fmt.Sprintf("%s%s#fallback%d", host, pth, i+1), pth, fbCfg); err != nil {
+					return nil, fmt.Errorf("create fallback #%d of %q failed: %s", i+1, host+pth, err)
+				}
+				if c, ok := fb.(io.Closer); ok {
+					rts.closers = append(rts.closers, c)
+				}
+				handlers = append(handlers, fb)
+			}
+			if fallback != nil {
+				handlers = append(handlers, fallback)
 			}
+			proxy = handlers
 		}
 
-		var (
-			handlers       Handlers
-			hasRootHandler = rootHandler != nil
-		)
+		if cfg.Compression != nil && !cfg.Compression.Disabled {
+			if proxy, err = compressionHandler(cfg.Compression, proxy); err != nil {
+				return nil, fmt.Errorf("route %q: %s", host+pth, err)
+			}
+		}
 
-		if hasRootHandler {
-			handlers = append(handlers, rootHandler)
+		if len(cfg.Rewrite) > 0 {
+			if proxy, err = rewriteHandler(cfg.Rewrite, proxy); err != nil {
+				return nil, fmt.Errorf("route %q: %s", host+pth, err)
+			}
 		}
 
-		handlers = append(handlers, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			if r.Header.Get("X-Httpdx-Handle-Fallback") != "false" {
-				fallback(w, r)
+		if cfg.Headers != nil {
+			if proxy, err = headersHandler(cfg.Headers, proxy); err != nil {
+				return nil, fmt.Errorf("route %q: %s", host+pth, err)
+			}
+		}
+
+		var limiter *rateLimiter
+		if limiter, err = newRateLimiter(cfg.RateLimit, time.Second); err != nil {
+			return nil, fmt.Errorf("route %q: %s", host+pth, err)
+		}
+
+		// the limits by user are applied after the authentication.
+		byIP, byUser := splitRateLimiters(limiter, rts.httpLimiter)
+		if len(byUser) > 0 {
+			proxy = rateLimitHandler(byUser, proxy)
+		}
+
+		if cfg.Auth == nil {
+			cfg.Auth = rts.cfg.HTTP.Auth
+		}
+		if err = rts.setupAuth(cfg.Auth); err != nil {
+			return nil, fmt.Errorf("route %q: %s", host+pth, err)
+		}
+		if cfg.Auth != nil && !cfg.Auth.Disabled {
+			proxy = basicAuth(cfg.Auth, proxy)
+		}
+
+		if len(byIP) > 0 {
+			proxy = rateLimitHandler(byIP, proxy)
+		}
+
+		// the preflight requests are answered before the authentication.
+		if cfg.CORS != nil && !cfg.CORS.Disabled {
+			if proxy, err = corsHandler(cfg.CORS, proxy); err != nil {
+				return nil, fmt.Errorf("route %q: %s", host+pth, err)
 			}
-		}))
+		}
+
+		var m *maintenance
+		if m, err = newMaintenance("HTTP", host+pth, cfg.Maintenance); err != nil {
+			return nil, fmt.Errorf("route %q: %s", host+pth, err)
+		}
+		if m != nil {
+			rts.maintenances = append(rts.maintenances, m)
+			proxy = maintenanceHandler(m, proxy)
+		}
 
-		rootHandler = handlers
+		var acl *accessList
+		if acl, err = newAccessList(cfg.Allow, cfg.Deny); err != nil {
+			return nil, fmt.Errorf("route %q: %s", host+pth, err)
+		}
+		if acl != nil {
+			proxy = accessListHandler(acl, host+pth, proxy)
+		}
+
+		if cfg.Timeouts != nil || cfg.MaxBodySize != 0 {
+			proxy = limitsHandler(cfg.Timeouts, cfg.MaxBodySize, proxy)
+		}
+
+		if len(cfg.ErrorPages) > 0 {
+			var pages errorPages
+			if pages, err = loadErrorPages(cfg.ErrorPages, rts.errorPages); err != nil {
+				return nil, fmt.Errorf("route %q: %s", host+pth, err)
+			}
+			proxy = errorPagesHandler(pages, proxy)
+		}
+
+		proxy = rts.routeHandler(host+pth, proxy)
+
+		if pth == "/" {
+			rootHandler = proxy
+		} else {
+			mux.Handle(pth, proxy)
+		}
+
+		rts.targets = append(rts.targets, fmt.Sprintf("%s%q 🡒 %s", prefix, pth, cfg.ToString(pth)))
+	}
+
+	// the not found handler serves the requests that doesn't match any route.
+	if rootHandler == nil {
+		rootHandler = fallback
 	}
 
 	if rootHandler != nil {
-		http.Handle("/", rootHandler)
+		mux.Handle("/", rootHandler)
 	}
 
-	return http.ListenAndServe(cfg.Addr, nil)
+	return mux, nil
 }
 
-type Handlers []http.Handler
+// createReverseProxy creates the handler of route. The name is the route
+// host followed by the route path.
+func createReverseProxy(name, pth string, cfg *HttpConfig) (http.Handler, error) {
+	if cfg.Redirect != nil {
+		return redirectHandler(cfg.Redirect)
+	}
 
-func (h Handlers) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	f := reflect.ValueOf(w).Elem().FieldByName("wroteHeader")
-	for _, h := range h {
-		if f.Bool() {
-			return
+	if cfg.Dir != "" {
+		return newStaticHandler(pth, cfg)
+	}
+
+	addrs := cfg.UpstreamAddrs()
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("addr is blank")
+	}
+
+	tlsConfig, err := cfg.UpstreamTLS.Build()
+	if err != nil {
+		return nil, fmt.Errorf("upstream tls: %s", err)
+	}
+
+	var upstreams []*upstream
+
+	for _, addr := range addrs {
+		u, err := newUpstream(name, pth, cfg, addr, tlsConfig)
+		if err != nil {
+			return nil, err
 		}
-		h.ServeHTTP(w, r)
+		upstreams = append(upstreams, u)
 	}
-}
 
-func createReverseProxy(pth string, cfg *HttpConfig) (http.Handler, error) {
-	if cfg.Dir != "" {
-		h := http.FileServer(http.FS(os.DirFS(cfg.Dir)))
-		if cfg.PathOverride == "" {
-			cfg.PathOverride = "%[1]s"
+	retry := newRetryPolicy(cfg.Retry)
+
+	sticky, err := newStickiness(cfg.Sticky, pth, upstreams)
+	if err != nil {
+		return nil, err
+	}
+
+	var handler http.Handler
+
+	if len(upstreams) == 1 && (cfg.HealthCheck == nil || cfg.HealthCheck.Disabled) && retry == nil {
+		handler = upstreams[0].proxy
+	} else {
+		pool, err := newUpstreamPool(name, cfg.Balance, upstreams)
+		if err != nil {
+			return nil, err
 		}
+		pool.retry = retry
+		pool.sticky = sticky
 
-		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			u := *r.URL
-			u.Path = "/" + strings.TrimPrefix(strings.TrimPrefix(u.Path, pth), "/")
-			if cfg.PathOverride != "" {
-				u.Path = fmt.Sprintf(cfg.PathOverride, u.Path, cfg.Dir, pth)
+		if cfg.HealthCheck != nil && !cfg.HealthCheck.Disabled {
+			go pool.healthCheck(cfg.HealthCheck)
+		}
+		handler = pool
+	}
+
+	if cfg.Mirror != nil && !cfg.Mirror.Disabled {
+		m, err := mirrorHandler(name, pth, cfg, tlsConfig, handler)
+		c, ok := handler.(io.Closer)
+		if err != nil {
+			if ok {
+				c.Close()
 			}
-			r2 := *r
-			r2.URL = &u
-			h.ServeHTTP(w, &r2)
-		}), nil
+			return nil, err
+		}
+		if ok {
+			return closerHandler{m, c}, nil
+		}
+		return m, nil
 	}
+	return handler, nil
+}
 
-	targetURL, err := url.Parse("http://" + cfg.Addr)
-	if err != nil {
-		return nil, err
+// closerHandler is a handler that closes the resources of the wrapped
+// handler.
+type closerHandler struct {
+	http.Handler
+	io.Closer
+}
+
+// newUpstream creates the upstream addr of route.
+func newUpstream(name, pth string, cfg *HttpConfig, addr string, tlsConfig *tls.Config) (u *upstream, err error) {
+	u = &upstream{addr: addr}
+	if u.url, err = upstreamURL(addr); err != nil {
+		return nil, fmt.Errorf("upstream %q: %s", addr, err)
 	}
-	rv := httputil.NewSingleHostReverseProxy(targetURL)
+	var headerTimeout time.Duration
+	if cfg.Timeouts != nil {
+		headerTimeout = seconds(cfg.Timeouts.UpstreamHeader)
+	}
+	u.transport = upstreamTransport(addr, tlsConfig, headerTimeout)
+	u.proxy = newReverseProxy(name, pth, cfg, u)
+	return
+}
+
+// newReverseProxy creates the reverse proxy of route to the upstream u.
+func newReverseProxy(name, pth string, cfg *HttpConfig, u *upstream) http.Handler {
+	addr := u.addr
+	rv := httputil.NewSingleHostReverseProxy(u.url)
+	rv.Transport = u.transport
 	if cfg.PathStrip {
 		headerName := cfg.PathHeader
 		if headerName == "" {
@@ -162,12 +615,14 @@ func createReverseProxy(pth string, cfg *HttpConfig) (http.Handler, error) {
 
 		oldDirector := rv.Director
 		rv.Director = func(r *http.Request) {
-			oldDirector(r)
+			// strips before joining the upstream base path.
 			if r.URL.Path == pth2 {
 				r.URL.Path = "/"
 			} else {
 				r.URL.Path = strings.TrimPrefix(r.URL.Path, pth2)
 			}
+			r.URL.RawPath = ""
+			oldDirector(r)
 			if s := r.Header.Get(headerName); s != "" {
 				r.Header.Set(headerName, path.Join(path.Clean(s), pth2))
 			} else {
@@ -176,11 +631,10 @@ func createReverseProxy(pth string, cfg *HttpConfig) (http.Handler, error) {
 		}
 	}
 	rv.ErrorHandler = func(writer http.ResponseWriter, request *http.Request, err error) {
-		if err.Error() != "EOF" {
-			http.Error(writer, err.Error(), http.StatusBadGateway)
-		}
+		metrics.upstreamErrors.Inc("http", name, addr)
+		proxyError(writer, request, err)
 	}
-	return rv, nil
+	return rv
 }
 
 const fallbackPage = `<!DOCTYPE html>
@@ -209,7 +663,7 @@ working. Further configuration is required.</p>
 <p>For online documentation and support please refer to
 <a href="https://github.com/moisespsena-go/httpdx">HTTPDx</a>.<br/>
 
-<p style="color:red">Warning: The requested page <code>%s</code> is unhandled.</strong></p>
+<p style="color:red">Warning: The requested page <code>{{.Path}}</code> is unhandled.</strong></p>
 
 <p><em>Thank you for using HTTPDx.</em></p>
 </body>
@@ -0,0 +1,53 @@
+package server
+
+import (
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestBuildRoutesInvalid(t *testing.T) {
+	for name, config := range map[string]string{
+		"default host": `
+http:
+  default_host: missing
+`,
+		"tcp addr": `
+tcp_sockets:
+  routes:
+    x: {addr: ""}
+`,
+		"server acl": `
+allow: [bad]
+`,
+		"tcp acl": `
+tcp_sockets:
+  routes:
+    x: {addr: "127.0.0.1:1", deny: [bad]}
+`,
+		"http rate limit": `
+http:
+  rate_limit: {rate: 0}
+`,
+		"tcp rate limit": `
+tcp_sockets:
+  routes:
+    x: {addr: "127.0.0.1:1", rate_limit: {rate: -1}}
+`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			var cfg Config
+			if err := yaml.Unmarshal([]byte(config), &cfg); err != nil {
+				t.Fatal(err)
+			}
+
+			rts, err := buildRoutes(&cfg)
+			if err == nil {
+				t.Fatal("buildRoutes: expected error")
+			}
+			if rts != nil {
+				t.Errorf("buildRoutes: expected nil routes, got %v", rts)
+			}
+		})
+	}
+}
@@ -0,0 +1,14 @@
+//go:build !windows
+
+package server
+
+import (
+	"os"
+	"syscall"
+)
+
+// reopenSignals is the signals that reopens the log files.
+var reopenSignals = []os.Signal{syscall.SIGUSR1}
+
+// maintenanceSignals is the signals that checks the maintenance flag files.
+var maintenanceSignals = []os.Signal{syscall.SIGUSR2}
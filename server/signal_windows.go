@@ -0,0 +1,9 @@
+package server
+
+import "os"
+
+// reopenSignals is the signals that reopens the log files.
+var reopenSignals []os.Signal
+
+// maintenanceSignals is the signals that checks the maintenance flag files.
+var maintenanceSignals []os.Signal
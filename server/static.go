@@ -0,0 +1,317 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type StaticConfig struct {
+	// SPAIndex if set, is the file served for not found paths, like
+	// index.html of single-page apps.
+	SPAIndex string `yaml:"spa_index"`
+	// ListingDisabled if true, responds not found to directories without
+	// index.html.
+	ListingDisabled bool `yaml:"listing_disabled"`
+	// HideDotfiles if true, responds not found to files and directories
+	// starting with ".".
+	HideDotfiles bool `yaml:"hide_dotfiles"`
+	// ETag if true, sends strong ETags of files content.
+	ETag bool `yaml:"etag"`
+	// Cache is the cache headers rules of files. The first matching rule is
+	// applied.
+	Cache []*StaticCacheConfig `yaml:"cache"`
+}
+
+type StaticCacheConfig struct {
+	// Match is a glob (see path.Match) matched against the file path, like
+	// /assets/*. If it doesn't contain "/", it is matched against the file
+	// name, like *.js.
+	Match string `yaml:"match"`
+	// CacheControl is the Cache-Control header value.
+	CacheControl string `yaml:"cache_control"`
+	// Expires if set, is the time in seconds of the Expires header.
+	Expires uint32 `yaml:"expires"`
+}
+
+// Matches reports whether the file name matches c.
+func (c *StaticCacheConfig) Matches(name string) bool {
+	if !strings.Contains(c.Match, "/") {
+		name = path.Base(name)
+	}
+	ok, _ := path.Match(c.Match, name)
+	return ok
+}
+
+// precompressedExts is the extensions of precompressed files by encoding.
+var precompressedExts = map[string]string{
+	EncodingBrotli: ".br",
+	EncodingGzip:   ".gz",
+}
+
+// newStaticHandler creates the handler of static files of route.
+func newStaticHandler(pth string, cfg *HttpConfig) (http.Handler, error) {
+	var (
+		fsys      = os.DirFS(cfg.Dir)
+		static    = cfg.Static
+		hfs       = http.FS(fsys)
+		etags     *etagCache
+		encodings []string
+	)
+
+	if static == nil {
+		static = &StaticConfig{}
+	}
+
+	for i, c := range static.Cache {
+		if _, err := path.Match(c.Match, ""); err != nil {
+			return nil, fmt.Errorf("static cache #%d: bad match %q", i, c.Match)
+		}
+	}
+
+	if static.HideDotfiles || static.ListingDisabled {
+		hfs = staticFS{hfs, static}
+	}
+
+	if static.ETag {
+		etags = &etagCache{items: map[string]*etagItem{}}
+	}
+
+	if cfg.PathOverride == "" {
+		cfg.PathOverride = "%[1]s"
+	}
+
+	if c := cfg.Compression; c != nil && !c.Disabled {
+		encodings = c.encodings()
+	}
+
+	h := http.FileServer(hfs)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u := *r.URL
+		u.Path = "/" + strings.TrimPrefix(strings.TrimPrefix(u.Path, pth), "/")
+		if cfg.PathOverride != "" {
+			u.Path = fmt.Sprintf(cfg.PathOverride, u.Path, cfg.Dir, pth)
+		}
+
+		info := stat(hfs, u.Path)
+
+		if info == nil && static.SPAIndex != "" && (r.Method == http.MethodGet || r.Method == http.MethodHead) {
+			u.Path = "/" + strings.TrimPrefix(static.SPAIndex, "/")
+			if info = stat(hfs, u.Path); info != nil && info.Mode().IsRegular() {
+				setCacheHeaders(w.Header(), static.Cache, u.Path)
+				// serves without FileServer, that redirects */index.html.
+				serveFile(w, r, hfs, u.Path, info, etags)
+				return
+			}
+		}
+
+		if info != nil && info.IsDir() && strings.HasSuffix(u.Path, "/") {
+			// the directory index is served by FileServer.
+			name := path.Join(u.Path, "index.html")
+			if index := stat(hfs, name); index != nil && index.Mode().IsRegular() {
+				setCacheHeaders(w.Header(), static.Cache, name)
+				if etags != nil {
+					if etag := etags.get(hfs, name, index); etag != "" {
+						w.Header().Set("ETag", etag)
+					}
+				}
+			}
+		}
+
+		if info != nil && info.Mode().IsRegular() {
+			setCacheHeaders(w.Header(), static.Cache, u.Path)
+
+			if len(encodings) > 0 && r.Header.Get("Range") == "" {
+				if enc, name := precompressed(fsys, u.Path, acceptedEncodings(r, encodings)); enc != "" {
+					w.Header().Add("Vary", "Accept-Encoding")
+					w.Header().Set("Content-Encoding", enc)
+					if ctype := mime.TypeByExtension(path.Ext(u.Path)); ctype != "" {
+						w.Header().Set("Content-Type", ctype)
+					}
+					u.Path = name
+					info = stat(hfs, name)
+				}
+			}
+
+			if etags != nil && info != nil {
+				if etag := etags.get(hfs, u.Path, info); etag != "" {
+					w.Header().Set("ETag", etag)
+				}
+			}
+		}
+
+		if pages := requestErrorPages(r); len(pages) > 0 {
+			w = &errorPageWriter{ResponseWriter: w, r: r, pages: pages}
+		}
+
+		r2 := *r
+		r2.URL = &u
+		h.ServeHTTP(w, &r2)
+	}), nil
+}
+
+// precompressed returns the accepted encoding and the name of precompressed
+// sibling file of name, if exists.
+func precompressed(fsys fs.FS, name string, accepted []string) (enc, sibling string) {
+	if strings.HasSuffix(name, "/") {
+		return
+	}
+	for _, enc = range accepted {
+		sibling = name + precompressedExts[enc]
+		if info, err := fs.Stat(fsys, strings.TrimPrefix(sibling, "/")); err == nil && info.Mode().IsRegular() {
+			return
+		}
+	}
+	return "", ""
+}
+
+// stat returns the info of file name, or nil if it can't be opened.
+func stat(hfs http.FileSystem, name string) fs.FileInfo {
+	f, err := hfs.Open(path.Clean(name))
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	info, _ := f.Stat()
+	return info
+}
+
+// setCacheHeaders sets the headers of the first rule matching name.
+func setCacheHeaders(h http.Header, rules []*StaticCacheConfig, name string) {
+	for _, c := range rules {
+		if !c.Matches(name) {
+			continue
+		}
+		if c.CacheControl != "" {
+			h.Set("Cache-Control", c.CacheControl)
+		}
+		if c.Expires > 0 {
+			h.Set("Expires", time.Now().Add(time.Second*time.Duration(c.Expires)).UTC().Format(http.TimeFormat))
+		}
+		return
+	}
+}
+
+// serveFile serves the regular file name.
+func serveFile(w http.ResponseWriter, r *http.Request, hfs http.FileSystem, name string, info fs.FileInfo, etags *etagCache) {
+	f, err := hfs.Open(name)
+	if err != nil {
+		writeError(w, r, http.StatusNotFound, "404 page not found")
+		return
+	}
+	defer f.Close()
+
+	if etags != nil {
+		if etag := etags.get(hfs, name, info); etag != "" {
+			w.Header().Set("ETag", etag)
+		}
+	}
+	http.ServeContent(w, r, name, info.ModTime(), f)
+}
+
+// staticFS hides the dotfiles and the directories without index.html.
+type staticFS struct {
+	http.FileSystem
+	cfg *StaticConfig
+}
+
+func (s staticFS) Open(name string) (http.File, error) {
+	if s.cfg.HideDotfiles {
+		for _, part := range strings.Split(name, "/") {
+			if strings.HasPrefix(part, ".") && part != "." {
+				return nil, fs.ErrNotExist
+			}
+		}
+	}
+
+	f, err := s.FileSystem.Open(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.cfg.ListingDisabled {
+		if info, err := f.Stat(); err == nil && info.IsDir() {
+			index, err := s.FileSystem.Open(path.Join(name, "index.html"))
+			if err != nil {
+				f.Close()
+				return nil, fs.ErrNotExist
+			}
+			index.Close()
+		}
+	}
+
+	if s.cfg.HideDotfiles {
+		return dotfileHidingFile{f}, nil
+	}
+	return f, nil
+}
+
+// dotfileHidingFile hides the dotfiles of directory listing.
+type dotfileHidingFile struct {
+	http.File
+}
+
+func (f dotfileHidingFile) Readdir(n int) (infos []fs.FileInfo, err error) {
+	files, err := f.File.Readdir(n)
+	for _, info := range files {
+		if !strings.HasPrefix(info.Name(), ".") {
+			infos = append(infos, info)
+		}
+	}
+	return
+}
+
+// etagCache is the strong ETags of files, by name, modification time and size.
+type etagCache struct {
+	mu    sync.Mutex
+	items map[string]*etagItem
+}
+
+type etagItem struct {
+	modTime time.Time
+	size    int64
+	etag    string
+}
+
+func (c *etagCache) get(hfs http.FileSystem, name string, info fs.FileInfo) string {
+	c.mu.Lock()
+	item := c.items[name]
+	c.mu.Unlock()
+
+	if item != nil && item.modTime.Equal(info.ModTime()) && item.size == info.Size() {
+		return item.etag
+	}
+
+	f, err := hfs.Open(name)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err = io.Copy(h, f); err != nil {
+		return ""
+	}
+
+	item = &etagItem{
+		modTime: info.ModTime(),
+		size:    info.Size(),
+		etag:    strconv.Quote(hex.EncodeToString(h.Sum(nil)[:16])),
+	}
+
+	c.mu.Lock()
+	c.items[name] = item
+	c.mu.Unlock()
+	return item.etag
+}
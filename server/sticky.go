@@ -0,0 +1,173 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+const (
+	StickyCookie = "cookie"
+	StickyHeader = "header"
+	StickyIP     = "ip"
+)
+
+// StickyConfig pins the clients to an upstream. If the pinned upstream is
+// unhealthy, the client is pinned to other upstream picked by the balance
+// method.
+type StickyConfig struct {
+	// Mode is the client key: cookie (default), header or ip.
+	Mode string `yaml:"mode"`
+	// Cookie is the cookie name of cookie mode (default is
+	// "httpdx_sticky"). The cookie value is the signed upstream ID.
+	Cookie string `yaml:"cookie"`
+	// MaxAge is the cookie max age in seconds. If zero, it is a session
+	// cookie.
+	MaxAge uint32 `yaml:"max_age"`
+	// Secret is the key to sign the cookie. If blank, uses a random key,
+	// so the clients are pinned again after restart or reload.
+	Secret string `yaml:"secret"`
+	// Header is the request header of header mode, like X-Session-Id. The
+	// requests without the header are balanced.
+	Header   string `yaml:"header"`
+	Disabled bool   `yaml:"disabled"`
+}
+
+func (c *StickyConfig) Defaults() {
+	if c.Mode == "" {
+		c.Mode = StickyCookie
+	}
+	if c.Cookie == "" {
+		c.Cookie = "httpdx_sticky"
+	}
+}
+
+// stickiness pins the clients to upstreams.
+type stickiness struct {
+	mode   string
+	cookie string
+	path   string
+	maxAge int
+	header string
+	key    []byte
+	ids    map[*upstream]string
+	byID   map[string]*upstream
+}
+
+// newStickiness creates the stickiness of cfg to upstreams of route path
+// pth. Returns nil if cfg is nil or disabled.
+func newStickiness(cfg *StickyConfig, pth string, upstreams []*upstream) (*stickiness, error) {
+	if cfg == nil || cfg.Disabled {
+		return nil, nil
+	}
+
+	cfg.Defaults()
+
+	s := &stickiness{
+		mode:   cfg.Mode,
+		cookie: cfg.Cookie,
+		path:   pth,
+		maxAge: int(cfg.MaxAge),
+		header: http.CanonicalHeaderKey(cfg.Header),
+		key:    []byte(cfg.Secret),
+		ids:    map[*upstream]string{},
+		byID:   map[string]*upstream{},
+	}
+
+	switch s.mode {
+	case StickyCookie:
+	case StickyHeader:
+		if s.header == "" {
+			return nil, fmt.Errorf("sticky: header is blank")
+		}
+	case StickyIP:
+	default:
+		return nil, fmt.Errorf("sticky: bad mode %q", s.mode)
+	}
+
+	if len(s.key) == 0 {
+		s.key = make([]byte, 32)
+		rand.Read(s.key)
+	}
+
+	for _, u := range upstreams {
+		sum := sha256.Sum256([]byte(u.addr))
+		id := hex.EncodeToString(sum[:6])
+		s.ids[u] = id
+		s.byID[id] = u
+	}
+	return s, nil
+}
+
+// sign returns the signed cookie value of upstream id.
+func (s *stickiness) sign(id string) string {
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write([]byte(s.path + "|" + id))
+	return id + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// cookieUpstream returns the upstream of signed cookie of r, if valid.
+func (s *stickiness) cookieUpstream(r *http.Request) *upstream {
+	c, err := r.Cookie(s.cookie)
+	if err != nil {
+		return nil
+	}
+	id, _, _ := strings.Cut(c.Value, ".")
+	if !hmac.Equal([]byte(c.Value), []byte(s.sign(id))) {
+		return nil
+	}
+	return s.byID[id]
+}
+
+// pinned returns the healthy upstream pinned to client of r, except the skip
+// ones. Returns nil if the client isn't pinned or the upstream is
+// unhealthy.
+func (s *stickiness) pinned(r *http.Request, p *upstreamPool, skip []*upstream) *upstream {
+	if s == nil {
+		return nil
+	}
+
+	switch s.mode {
+	case StickyCookie:
+		if u := s.cookieUpstream(r); u != nil && u.healthy.Load() && !slices.Contains(skip, u) {
+			return u
+		}
+	case StickyHeader:
+		if key := r.Header.Get(s.header); key != "" {
+			return p.hashPick(key, skip)
+		}
+	case StickyIP:
+		return p.hashPick(clientIP(r), skip)
+	}
+	return nil
+}
+
+// pin pins the client of r to upstream u. On cookie mode, sets the cookie if
+// it doesn't name u.
+func (s *stickiness) pin(w http.ResponseWriter, r *http.Request, u *upstream) {
+	if s == nil || s.mode != StickyCookie || s.cookieUpstream(r) == u {
+		return
+	}
+
+	// removes the cookie of previous attempt
+	h := w.Header()
+	h["Set-Cookie"] = slices.DeleteFunc(h["Set-Cookie"], func(v string) bool {
+		return strings.HasPrefix(v, s.cookie+"=")
+	})
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     s.cookie,
+		Value:    s.sign(s.ids[u]),
+		Path:     s.path,
+		MaxAge:   s.maxAge,
+		Secure:   r.TLS != nil,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
@@ -1,12 +1,13 @@
 package server
 
 import (
-	"crypto/sha256"
-	"crypto/subtle"
+	"context"
 	"fmt"
 	"io"
 	"net"
 	"net/http"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
@@ -18,11 +19,18 @@ const BufferSize = 256 * 1024
 
 // Handler handlers
 type Handler struct {
-	handlers          map[string]*TCPSocketConfig
+	handlers          atomic.Pointer[map[string]*TCPSocketConfig]
 	upgrader          websocket.Upgrader
 	dialTimeout       time.Duration
 	writeTimeout      time.Duration
 	enableCompression bool
+
+	mu        sync.Mutex
+	tunnels   map[*websocket.Conn]bool
+	wg        sync.WaitGroup
+	shutdown  atomic.Bool
+	accessLog atomic.Pointer[accessLog]
+	acl       atomic.Pointer[accessList]
 }
 
 // New new handler
@@ -43,31 +51,112 @@ func New(
 		},
 	}
 
-	return &Handler{
-		handlers:     handlers,
+	h := &Handler{
 		upgrader:     upgrader,
 		dialTimeout:  dialTimeout,
 		writeTimeout: writeTimeout,
+		tunnels:      map[*websocket.Conn]bool{},
 	}
+	h.SetRoutes(handlers)
+	return h
+}
+
+// SetRoutes replaces the routes. The established tunnels aren't affected.
+func (h *Handler) SetRoutes(handlers map[string]*TCPSocketConfig) {
+	h.handlers.Store(&handlers)
+}
+
+// authorize returns the route of tunnel name, or the reason to reject the
+// tunnel, and the time to wait if rejected by rate limits.
+func (h *Handler) authorize(r *http.Request, name string, session *tunnelSession) (sck *TCPSocketConfig, reject string, retryAfter time.Duration) {
+	if name == "" {
+		return nil, "name is blank", 0
+	}
+
+	if name == internal.TestRoute {
+		return
+	}
+
+	if !h.acl.Load().Allowed(session.RemoteAddr) {
+		logDenied("TCP", name, session.RemoteAddr)
+		return nil, "access denied", 0
+	}
+
+	sck = (*h.handlers.Load())[name]
+	if sck == nil || sck.Disabled {
+		return nil, fmt.Sprintf("%q is not registered", name), 0
+	}
+
+	if !sck.acl.Allowed(session.RemoteAddr) {
+		logDenied("TCP", name, session.RemoteAddr)
+		return nil, "access denied", 0
+	}
+
+	if m := sck.maintenance; m.Active(session.RemoteAddr) {
+		return nil, m.message, m.retryAfter
+	}
+
+	var user string
+
+	if sck.Auth != nil && !sck.Auth.Disabled {
+		username, password, _ := r.BasicAuth()
+		session.User = username
+		if !sck.Auth.Check(username, password) {
+			metrics.authFailures.Inc("tunnel", name)
+			return nil, "invalid username or password", 0
+		}
+		user = username
+	}
+
+	if ok, d := allowAll(sck.limiters, session.RemoteAddr, user); !ok {
+		metrics.rateLimited.Inc("tunnel", name)
+		return nil, fmt.Sprintf("rate limit exceeded, retry after %ss", retryAfterSeconds(d)), d
+	}
+	return
 }
 
 // Proxy proxy handler
 func (h *Handler) Proxy() func(w http.ResponseWriter, r *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
-		wc, err := h.upgrader.Upgrade(w, r, nil)
+		var (
+			name    = r.URL.Query().Get("name")
+			session = &tunnelSession{
+				Time:       time.Now(),
+				Route:      name,
+				RemoteAddr: clientIP(r),
+			}
+			sck, reject, retryAfter = h.authorize(r, name, session)
+			header                  http.Header
+		)
+
+		if retryAfter > 0 {
+			header = http.Header{"Retry-After": {retryAfterSeconds(retryAfter)}}
+		}
+
+		wc, err := h.upgrader.Upgrade(w, r, header)
 		if err != nil {
+			metrics.upgradeFailures.Inc()
 			http.Error(w, "WEBSOCKET failed: "+err.Error(), http.StatusPreconditionFailed)
 			return
 		}
 
+		h.track(wc)
+		defer h.untrack(wc)
+
+		defer func() {
+			if l := h.accessLog.Load(); l != nil && name != internal.TestRoute {
+				l.logTunnel(session)
+			}
+		}()
+
 		fail := func(msg string) {
+			session.Reason = msg
 			wc.WriteMessage(websocket.TextMessage, []byte("ERROR: "+msg))
 			wc.Close()
 		}
 
-		name := r.URL.Query().Get("name")
-		if name == "" {
-			fail("name is blank")
+		if reject != "" {
+			fail(reject)
 			return
 		}
 
@@ -76,53 +165,52 @@ func (h *Handler) Proxy() func(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		sck := h.handlers[name]
-		if sck.Addr == "" {
-			fail(fmt.Sprintf("%q is not registered"))
-			return
-		}
+		var s net.Conn
 
-		if sck.Auth != nil && !sck.Auth.Disabled {
-			username, password, _ := r.BasicAuth()
-			// Calculate SHA-256 hashes for the provided and expected
-			// usernames and passwords.
-			usernameHash := sha256.Sum256([]byte(username))
-			passwordHash := sha256.Sum256([]byte(password))
-			expectedUsernameHash := sha256.Sum256([]byte(sck.Auth.User))
-			expectedPasswordHash := sha256.Sum256([]byte(sck.Auth.Password))
-
-			usernameMatch := (subtle.ConstantTimeCompare(usernameHash[:], expectedUsernameHash[:]) == 1)
-			passwordMatch := (subtle.ConstantTimeCompare(passwordHash[:], expectedPasswordHash[:]) == 1)
-			if !usernameMatch || !passwordMatch {
-				fail("invalid username or password")
-				return
+		for i, addr := range sck.Addrs() {
+			if i > 0 {
+				metrics.upstreamRetries.Inc("tunnel", name)
 			}
+			network, address := splitNetwork(addr)
+			if s, err = net.DialTimeout(network, address, h.dialTimeout); err == nil {
+				break
+			}
+			metrics.upstreamErrors.Inc("tunnel", name, addr)
 		}
 
-		s, err := net.DialTimeout("tcp", sck.Addr, h.dialTimeout)
-
 		if err != nil {
-			http.Error(w, fmt.Sprintf("Could not connect upstream: %v", err), 500)
+			fail(fmt.Sprintf("could not connect upstream: %v", err))
 			return
 		}
 
+		metrics.tunnels.Inc(name)
+		metrics.tunnelsActive.Inc(name)
+
+		defer func() {
+			metrics.tunnelsActive.Add(-1, name)
+			metrics.tunnelBytes.Add(session.BytesIn, name, "in")
+			metrics.tunnelBytes.Add(session.BytesOut, name, "out")
+		}()
+
 		rwc := &wsConnRW{c: wc}
 
-		doneCh := make(chan bool)
+		doneCh := make(chan string)
 
 		// websocket -> server
 		go func() {
-			defer func() { doneCh <- true }()
-			io.Copy(s, rwc)
+			var err error
+			defer func() { doneCh <- h.closeReason("client", err) }()
+			session.BytesIn, err = io.Copy(s, rwc)
 		}()
 
 		// server -> websocket
 		go func() {
-			defer func() { doneCh <- true }()
-			io.Copy(rwc, s)
+			var err error
+			defer func() { doneCh <- h.closeReason("upstream", err) }()
+			session.BytesOut, err = io.Copy(rwc, s)
 		}()
 
-		<-doneCh
+		session.Reason = <-doneCh
 		s.Close()
 		wc.Close()
 		<-doneCh
@@ -130,6 +218,73 @@ func (h *Handler) Proxy() func(w http.ResponseWriter, r *http.Request) {
 
 }
 
+// closeReason returns the reason of tunnel close by the side that finished
+// the copy first.
+func (h *Handler) closeReason(side string, err error) string {
+	if h.shutdown.Load() {
+		return "shutdown"
+	}
+	if err != nil {
+		return side + " error: " + err.Error()
+	}
+	return side + " closed"
+}
+
+// SetAccessLog sets the access log of tunnel sessions. If l is nil,
+// disables it.
+func (h *Handler) SetAccessLog(l *accessLog) {
+	h.accessLog.Store(l)
+}
+
+// SetAccessList sets the access list of all tunnels. If l is nil, all
+// clients are allowed.
+func (h *Handler) SetAccessList(l *accessList) {
+	h.acl.Store(l)
+}
+
+func (h *Handler) track(wc *websocket.Conn) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.tunnels[wc] = true
+	h.wg.Add(1)
+}
+
+func (h *Handler) untrack(wc *websocket.Conn) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.tunnels[wc] {
+		delete(h.tunnels, wc)
+		h.wg.Done()
+	}
+}
+
+// Shutdown waits for the active tunnels until ctx is done, then closes the
+// remaining tunnels. Returns the number of closed tunnels.
+func (h *Handler) Shutdown(ctx context.Context) (closed int) {
+	done := make(chan struct{})
+	go func() {
+		h.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return
+	case <-ctx.Done():
+	}
+
+	h.shutdown.Store(true)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for wc := range h.tunnels {
+		wc.Close()
+		closed++
+	}
+	return
+}
+
 type wsConnRW struct {
 	c *websocket.Conn
 	r io.Reader
@@ -0,0 +1,181 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// TimeoutsConfig is the timeouts in seconds. Zero values are unlimited, or
+// inherited from the server timeouts on routes.
+type TimeoutsConfig struct {
+	// ReadHeader is the time to read the request headers (server only).
+	ReadHeader uint16 `yaml:"read_header"`
+	// Read is the time to read the whole request, including the body.
+	Read uint16 `yaml:"read"`
+	// Write is the time to write the response.
+	Write uint16 `yaml:"write"`
+	// Idle is the time to wait the next request of keep-alive connections
+	// (server only).
+	Idle uint16 `yaml:"idle"`
+	// UpstreamHeader is the time to wait the upstream response headers.
+	UpstreamHeader uint16 `yaml:"upstream_header"`
+	// Upstream is the time to get the whole upstream response.
+	Upstream uint16 `yaml:"upstream"`
+}
+
+// Over returns the timeouts of c over the non zero timeouts of parent.
+func (c *TimeoutsConfig) Over(parent *TimeoutsConfig) *TimeoutsConfig {
+	if c == nil {
+		return parent
+	}
+	if parent == nil {
+		return c
+	}
+
+	t := *c
+	for _, f := range [][2]*uint16{
+		{&t.ReadHeader, &parent.ReadHeader},
+		{&t.Read, &parent.Read},
+		{&t.Write, &parent.Write},
+		{&t.Idle, &parent.Idle},
+		{&t.UpstreamHeader, &parent.UpstreamHeader},
+		{&t.Upstream, &parent.Upstream},
+	} {
+		if *f[0] == 0 {
+			*f[0] = *f[1]
+		}
+	}
+	return &t
+}
+
+func seconds(v uint16) time.Duration {
+	return time.Second * time.Duration(v)
+}
+
+// setup sets the server timeouts of srv.
+func (c *TimeoutsConfig) setup(srv *http.Server) {
+	if c == nil {
+		return
+	}
+	srv.ReadHeaderTimeout = seconds(c.ReadHeader)
+	srv.ReadTimeout = seconds(c.Read)
+	srv.WriteTimeout = seconds(c.Write)
+	srv.IdleTimeout = seconds(c.Idle)
+}
+
+const bodyKey contextKey = "body"
+
+// requestBody records the read error of request body.
+type requestBody struct {
+	io.ReadCloser
+	err error
+}
+
+func (b *requestBody) Read(p []byte) (n int, err error) {
+	if n, err = b.ReadCloser.Read(p); err != nil && err != io.EOF {
+		b.err = err
+	}
+	return
+}
+
+// bodyError returns the read error of request body, if any.
+func bodyError(r *http.Request) error {
+	if b, _ := r.Context().Value(bodyKey).(*requestBody); b != nil {
+		return b.err
+	}
+	return nil
+}
+
+// bodyErrorStatus returns the status code of request body read error err:
+// 413 if the body is too large, 408 on timeout, otherwise 400.
+func bodyErrorStatus(err error) int {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return http.StatusRequestEntityTooLarge
+	}
+	if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		return http.StatusRequestTimeout
+	}
+	return http.StatusBadRequest
+}
+
+// isUpgrade reports whether r is a connection upgrade request, like
+// websocket.
+func isUpgrade(r *http.Request) bool {
+	for _, v := range r.Header.Values("Connection") {
+		for _, s := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(s), "upgrade") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// limitsHandler applies the timeouts of t and the maximum request body size
+// to requests before serve next. If maxBody is negative, the body size is
+// unlimited.
+func limitsHandler(t *TimeoutsConfig, maxBody int64, next http.Handler) http.Handler {
+	if t == nil {
+		t = &TimeoutsConfig{}
+	}
+
+	var (
+		read     = seconds(t.Read)
+		write    = seconds(t.Write)
+		upstream = seconds(t.Upstream)
+	)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if maxBody > 0 && r.ContentLength > maxBody {
+			writeError(w, r, http.StatusRequestEntityTooLarge, "Request Entity Too Large")
+			return
+		}
+
+		var (
+			rc      = http.NewResponseController(w)
+			hasBody = r.Body != nil && r.Body != http.NoBody
+		)
+		if isUpgrade(r) {
+			// the upgraded connections are long lived
+			rc.SetReadDeadline(time.Time{})
+			rc.SetWriteDeadline(time.Time{})
+		} else {
+			now := time.Now()
+			// without body, the server is already reading the next request
+			if read > 0 && hasBody {
+				rc.SetReadDeadline(now.Add(read))
+			}
+			if write > 0 {
+				rc.SetWriteDeadline(now.Add(write))
+			}
+		}
+
+		ctx := r.Context()
+		if upstream > 0 && !isUpgrade(r) {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, upstream)
+			defer cancel()
+		}
+
+		if hasBody {
+			body := r.Body
+			if maxBody > 0 {
+				body = http.MaxBytesReader(w, body, maxBody)
+			}
+			b := &requestBody{ReadCloser: body}
+			ctx = context.WithValue(ctx, bodyKey, b)
+			r = r.WithContext(ctx)
+			r.Body = b
+		} else if ctx != r.Context() {
+			r = r.WithContext(ctx)
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,262 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+type TLSConfig struct {
+	// CertFile and KeyFile are the default certificate pair. It is used when
+	// the client does not send SNI or no certificate of CertsDir matches.
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+	// CertsDir is a directory with certificate pairs selected by SNI.
+	// Each NAME.crt (or NAME.pem) needs a NAME.key sibling.
+	CertsDir string `yaml:"certs_dir"`
+	// MinVersion is the minimum TLS version: 1.0, 1.1, 1.2 (default) or 1.3.
+	MinVersion string `yaml:"min_version"`
+	// CipherSuites is the allowed cipher suites names for TLS 1.0-1.2.
+	// If not set, uses the Go defaults.
+	CipherSuites []string `yaml:"cipher_suites"`
+	// RedirectAddr if set, starts a plain HTTP listener on this address
+	// that redirects all requests to HTTPS.
+	RedirectAddr string `yaml:"redirect_addr"`
+	Disabled     bool   `yaml:"disabled"`
+}
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// Build builds the *tls.Config from this configuration.
+func (c *TLSConfig) Build() (_ *tls.Config, err error) {
+	var (
+		store  = &certStore{byName: map[string]*tls.Certificate{}}
+		config = &tls.Config{
+			MinVersion:     tls.VersionTLS12,
+			GetCertificate: store.get,
+		}
+	)
+
+	if c.MinVersion != "" {
+		var ok bool
+		if config.MinVersion, ok = tlsVersions[c.MinVersion]; !ok {
+			return nil, fmt.Errorf("bad min_version %q", c.MinVersion)
+		}
+	}
+
+	if len(c.CipherSuites) > 0 {
+		if config.CipherSuites, err = cipherSuites(c.CipherSuites); err != nil {
+			return
+		}
+	}
+
+	if c.CertFile != "" || c.KeyFile != "" {
+		if store.def, err = loadCert(c.CertFile, c.KeyFile); err != nil {
+			return
+		}
+	}
+
+	if c.CertsDir != "" {
+		if err = store.loadDir(c.CertsDir); err != nil {
+			return
+		}
+	}
+
+	if store.def == nil {
+		if len(store.names) == 0 {
+			return nil, fmt.Errorf("no certificates configured")
+		}
+		store.def = store.byName[store.names[0]]
+	}
+	return config, nil
+}
+
+// UpstreamTLSConfig is the TLS options of connections to HTTPS upstreams.
+type UpstreamTLSConfig struct {
+	// CAFile is a PEM bundle of CAs to verify the upstream certificate. If
+	// not set, uses the system CAs.
+	CAFile string `yaml:"ca_file"`
+	// CertFile and KeyFile are the client certificate pair sent to the
+	// upstream (mTLS).
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+	// ServerName overrides the name sent by SNI and used to verify the
+	// upstream certificate.
+	ServerName string `yaml:"server_name"`
+	// InsecureSkipVerify if true, doesn't verify the upstream certificate.
+	// Use it only for tests.
+	InsecureSkipVerify bool `yaml:"insecure_skip_verify"`
+	Disabled           bool `yaml:"disabled"`
+}
+
+// Build builds the *tls.Config from this configuration. Returns nil if c is
+// nil or disabled.
+func (c *UpstreamTLSConfig) Build() (_ *tls.Config, err error) {
+	if c == nil || c.Disabled {
+		return
+	}
+
+	config := &tls.Config{
+		ServerName:         c.ServerName,
+		InsecureSkipVerify: c.InsecureSkipVerify,
+	}
+
+	if c.CAFile != "" {
+		var pem []byte
+		if pem, err = os.ReadFile(c.CAFile); err != nil {
+			return
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates in %q", c.CAFile)
+		}
+	}
+
+	if c.CertFile != "" || c.KeyFile != "" {
+		var cert *tls.Certificate
+		if cert, err = loadCert(c.CertFile, c.KeyFile); err != nil {
+			return
+		}
+		config.Certificates = []tls.Certificate{*cert}
+	}
+	return config, nil
+}
+
+func cipherSuites(names []string) (ids []uint16, err error) {
+	available := map[string]uint16{}
+	for _, s := range tls.CipherSuites() {
+		available[s.Name] = s.ID
+	}
+	for _, s := range tls.InsecureCipherSuites() {
+		available[s.Name] = s.ID
+	}
+	for _, name := range names {
+		id, ok := available[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown cipher suite %q", name)
+		}
+		ids = append(ids, id)
+	}
+	return
+}
+
+func loadCert(certFile, keyFile string) (*tls.Certificate, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("load certificate %q: %s", certFile, err)
+	}
+	if cert.Leaf == nil {
+		if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+			return nil, fmt.Errorf("parse certificate %q: %s", certFile, err)
+		}
+	}
+	return &cert, nil
+}
+
+type certStore struct {
+	def    *tls.Certificate
+	byName map[string]*tls.Certificate
+	names  []string
+}
+
+func (s *certStore) loadDir(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	for _, e := range entries {
+		ext := filepath.Ext(e.Name())
+		if e.IsDir() || (ext != ".crt" && ext != ".pem") {
+			continue
+		}
+
+		var (
+			certFile = filepath.Join(dir, e.Name())
+			keyFile  = strings.TrimSuffix(certFile, ext) + ".key"
+		)
+
+		if _, err := os.Stat(keyFile); err != nil {
+			continue
+		}
+
+		cert, err := loadCert(certFile, keyFile)
+		if err != nil {
+			return err
+		}
+
+		names := cert.Leaf.DNSNames
+		if len(names) == 0 && cert.Leaf.Subject.CommonName != "" {
+			names = []string{cert.Leaf.Subject.CommonName}
+		}
+
+		for _, name := range names {
+			name = strings.ToLower(name)
+			if _, ok := s.byName[name]; !ok {
+				s.names = append(s.names, name)
+			}
+			s.byName[name] = cert
+		}
+	}
+
+	sort.Strings(s.names)
+	return nil
+}
+
+func (s *certStore) get(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	name := strings.ToLower(strings.TrimSuffix(hello.ServerName, "."))
+	if name != "" {
+		if cert := s.byName[name]; cert != nil {
+			return cert, nil
+		}
+		if i := strings.IndexByte(name, '.'); i > 0 {
+			if cert := s.byName["*"+name[i:]]; cert != nil {
+				return cert, nil
+			}
+		}
+	}
+	return s.def, nil
+}
+
+// redirectToHTTPS redirects plain HTTP requests to the HTTPS server
+// listening on addr.
+func redirectToHTTPS(addr string) http.Handler {
+	_, port, _ := net.SplitHostPort(addr)
+	if port == "443" {
+		port = ""
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if port != "" {
+			host = net.JoinHostPort(host, port)
+		}
+
+		u := *r.URL
+		u.Scheme = "https"
+		u.Host = host
+		http.Redirect(w, r, u.String(), http.StatusMovedPermanently)
+	})
+}
+
+func serveRedirect(srv *http.Server) {
+	log.Printf("Starting HTTP to HTTPS redirect server on %s", srv.Addr)
+	if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		log.Printf("HTTP to HTTPS redirect server failed: %s", err)
+	}
+}
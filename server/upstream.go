@@ -0,0 +1,71 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const unixAddrPrefix = "unix:"
+
+// splitNetwork returns the network and the address of upstream addr. The
+// addr "unix:/path/to.sock" is a Unix domain socket, otherwise is TCP.
+func splitNetwork(addr string) (network, address string) {
+	if strings.HasPrefix(addr, unixAddrPrefix) {
+		return "unix", strings.TrimPrefix(addr, unixAddrPrefix)
+	}
+	return "tcp", addr
+}
+
+// upstreamURL returns the base URL of HTTP upstream addr. The addr without
+// scheme uses http.
+func upstreamURL(addr string) (*url.URL, error) {
+	if network, _ := splitNetwork(addr); network == "unix" {
+		return &url.URL{Scheme: "http", Host: "localhost"}, nil
+	}
+
+	if !strings.Contains(addr, "://") {
+		addr = "http://" + addr
+	}
+
+	u, err := url.Parse(addr)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("bad scheme %q", u.Scheme)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("host is blank")
+	}
+	return u, nil
+}
+
+// upstreamTransport returns the HTTP transport to upstream addr. If tlsConfig
+// isn't nil, it is used by HTTPS connections.
+func upstreamTransport(addr string, tlsConfig *tls.Config, headerTimeout time.Duration) http.RoundTripper {
+	network, address := splitNetwork(addr)
+	if network != "unix" && tlsConfig == nil && headerTimeout == 0 {
+		return http.DefaultTransport
+	}
+
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.ResponseHeaderTimeout = headerTimeout
+
+	if tlsConfig != nil {
+		t.TLSClientConfig = tlsConfig.Clone()
+	}
+
+	if network == "unix" {
+		var dialer net.Dialer
+		t.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return dialer.DialContext(ctx, network, address)
+		}
+	}
+	return t
+}
@@ -0,0 +1,65 @@
+package server
+
+import (
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+type wildcardHost struct {
+	suffix  string
+	handler http.Handler
+}
+
+// hostRouter dispatches requests by the request host. Exact names have
+// precedence over wildcards (*.example.com), and the longest wildcard wins.
+type hostRouter struct {
+	exact     map[string]http.Handler
+	wildcards []wildcardHost
+	def       http.Handler
+}
+
+func newHostRouter() *hostRouter {
+	return &hostRouter{exact: map[string]http.Handler{}}
+}
+
+func (h *hostRouter) Add(host string, handler http.Handler) {
+	host = normalizeHost(host)
+	if strings.HasPrefix(host, "*.") {
+		h.wildcards = append(h.wildcards, wildcardHost{host[1:], handler})
+		sort.SliceStable(h.wildcards, func(i, j int) bool {
+			return len(h.wildcards[i].suffix) > len(h.wildcards[j].suffix)
+		})
+		return
+	}
+	h.exact[host] = handler
+}
+
+func (h *hostRouter) Match(host string) http.Handler {
+	host = normalizeHost(host)
+	if handler := h.exact[host]; handler != nil {
+		return handler
+	}
+	for _, w := range h.wildcards {
+		if strings.HasSuffix(host, w.suffix) {
+			return w.handler
+		}
+	}
+	return h.def
+}
+
+func (h *hostRouter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if handler := h.Match(r.Host); handler != nil {
+		handler.ServeHTTP(w, r)
+		return
+	}
+	writeError(w, r, http.StatusNotFound, "404 page not found")
+}
+
+func normalizeHost(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.TrimSuffix(strings.ToLower(host), ".")
+}